@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type StringStat struct {
+	Value           string   `json:"value"`
+	Length          int      `json:"length"`
+	Count           int      `json:"count"`
+	DuplicatedBytes int      `json:"duplicatedBytes"`
+	Modules         []string `json:"modules"`
+}
+
+// Scan a module body for quoted string literals
+func scanStringLiterals(content []byte) []string {
+	literals := []string{}
+
+	for i := 0; i < len(content); i++ {
+		quote := content[i]
+		if quote != '"' && quote != '\'' && quote != '`' {
+			continue
+		}
+
+		start := i
+		for i++; i < len(content) && content[i] != quote; i++ {
+			if content[i] == '\\' {
+				i++
+			}
+		}
+
+		if i < len(content) {
+			literals = append(literals, string(content[start:i+1]))
+		}
+	}
+
+	return literals
+}
+
+// Report the string literals that are repeated the most across modules
+func analyzeStrings(modules *map[string][]byte) {
+	stats := map[string]*StringStat{}
+
+	for moduleID, content := range *modules {
+		for _, literal := range scanStringLiterals(content) {
+			if len(literal) < minLength {
+				continue
+			}
+
+			stat, ok := stats[literal]
+			if !ok {
+				stat = &StringStat{Value: literal, Length: len(literal)}
+				stats[literal] = stat
+			}
+
+			stat.Count++
+			if len(stat.Modules) == 0 || stat.Modules[len(stat.Modules)-1] != moduleID {
+				stat.Modules = append(stat.Modules, moduleID)
+			}
+		}
+	}
+
+	ranked := []*StringStat{}
+	for _, stat := range stats {
+		if stat.Count < 2 {
+			continue
+		}
+
+		stat.DuplicatedBytes = (stat.Count - 1) * stat.Length
+		sort.Strings(stat.Modules)
+		ranked = append(ranked, stat)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].DuplicatedBytes != ranked[j].DuplicatedBytes {
+			return ranked[i].DuplicatedBytes > ranked[j].DuplicatedBytes
+		}
+
+		return ranked[i].Value < ranked[j].Value
+	})
+
+	if top > 0 && len(ranked) > top {
+		ranked = ranked[:top]
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(ranked, "", "  ")
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		fmt.Println(string(output))
+		return
+	}
+
+	fmt.Printf("%-6v %-10v %-8v %-8v %v\n", "Rank", "Duplicated", "Count", "Modules", "Value")
+	for index, stat := range ranked {
+		value := stat.Value
+		if len(value) > 60 {
+			value = value[:57] + "..."
+		}
+
+		fmt.Printf("%-6v %-10v %-8v %-8v %v\n", index+1, stat.DuplicatedBytes, stat.Count, len(stat.Modules), value)
+	}
+}
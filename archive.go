@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path/filepath"
+)
+
+// Where unpack writes the module files and the manifest
+type UnpackWriter interface {
+	WriteFile(name string, content []byte) error
+	Close() error
+
+	// Remove what was written unless Close moved it to the output
+	Abort()
+}
+
+type dirWriter struct {
+	root string
+	partialOutput
+}
+
+type tarWriter struct {
+	file   *os.File
+	writer *tar.Writer
+	partialOutput
+}
+
+type zipWriter struct {
+	file   *os.File
+	writer *zip.Writer
+	partialOutput
+}
+
+// An output written under a hidden name next to its path and moved there once it's complete, so a
+// failed unpack doesn't leave half of one behind
+type partialOutput struct {
+	partial string
+	path    string
+}
+
+// Get the partial output of path, removed if the run fails before it's complete
+func newPartialOutput(path string, partial string) partialOutput {
+	output := partialOutput{partial: partial, path: path}
+	onFail(output.Abort)
+
+	return output
+}
+
+// Move the complete output to its path, the empty folder unpack was allowed to write into included
+func (o partialOutput) commit() error {
+	if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(o.partial, o.path)
+}
+
+func (o partialOutput) Abort() {
+	os.RemoveAll(o.partial)
+}
+
+// Open the writer for -archive-format, writing to the -o folder or archive
+func newUnpackWriter() (UnpackWriter, error) {
+	dir, base := filepath.Dir(filepath.Clean(outputDir)), filepath.Base(filepath.Clean(outputDir))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	switch archiveFormat {
+	case "tar", "zip":
+		file, err := os.CreateTemp(dir, "."+base+"-partial-")
+		if err != nil {
+			return nil, err
+		}
+
+		partial := newPartialOutput(outputDir, file.Name())
+		if err := file.Chmod(0644); err != nil {
+			return nil, err
+		}
+
+		if archiveFormat == "tar" {
+			return &tarWriter{file: file, writer: tar.NewWriter(file), partialOutput: partial}, nil
+		}
+
+		return &zipWriter{file: file, writer: zip.NewWriter(file), partialOutput: partial}, nil
+	}
+
+	root, err := os.MkdirTemp(dir, "."+base+"-partial-")
+	if err != nil {
+		return nil, err
+	}
+
+	partial := newPartialOutput(outputDir, root)
+	if err := os.Chmod(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &dirWriter{root: root, partialOutput: partial}, nil
+}
+
+func (w *dirWriter) WriteFile(name string, content []byte) error {
+	path := filepath.Join(w.root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return withRetries(func() error {
+		return os.WriteFile(path, content, 0666)
+	})
+}
+
+func (w *dirWriter) Close() error {
+	return w.commit()
+}
+
+func (w *tarWriter) WriteFile(name string, content []byte) error {
+	header := &tar.Header{
+		Name:    filepath.ToSlash(name),
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: outputTime(),
+	}
+
+	if err := w.writer.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := w.writer.Write(content)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.writer.Close(); err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	return w.commit()
+}
+
+func (w *zipWriter) WriteFile(name string, content []byte) error {
+	header := &zip.FileHeader{
+		Name:     filepath.ToSlash(name),
+		Method:   zip.Deflate,
+		Modified: outputTime(),
+	}
+
+	file, err := w.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	if err := w.writer.Close(); err != nil {
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	return w.commit()
+}
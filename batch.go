@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The environment variable telling a run of the batch where to write its BatchReport
+const BATCH_REPORT_ENV = "JSBUNDLETOOLS_BATCH_REPORT"
+
+type BatchResult struct {
+	Bundle string
+	Err    error
+	Report *BatchReport
+	Output string
+}
+
+// What a run of the batch reports back once it's done
+type BatchReport struct {
+	Patches int `json:"patches"`
+}
+
+// The patches that changed at least one module, reported to the batch
+var changingPatches = 0
+
+// Check if -p is a glob matching several bundles, a bundle with glob characters in its name isn't one
+func isBatch() bool {
+	if mode != "unpack" && mode != "patch" || !strings.ContainsAny(bundlePath, "*?[") {
+		return false
+	}
+
+	_, err := os.Stat(bundlePath)
+	return err != nil
+}
+
+// Run the tool on every bundle matching the -p glob, at most -jobs at a time, each in its own process
+// since a run uses the global flags
+func runBatch() {
+	bundles, err := filepath.Glob(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	if len(bundles) == 0 {
+		fail("No bundles match %v.\n", bundlePath)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	// The other flags are passed on as they are
+	args := []string{}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "p" && f.Name != "o" && f.Name != "n" && f.Name != "jobs" {
+			args = append(args, fmt.Sprintf("-%v=%v", f.Name, f.Value.String()))
+		}
+	})
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fail("%v\n", err)
+	}
+
+	reportDir, err := os.MkdirTemp("", "jsbundletools-batch")
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	results := make([]BatchResult, len(bundles))
+	limit := make(chan struct{}, max(jobs, 1))
+	var wait sync.WaitGroup
+
+	for index, bundle := range bundles {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			name := strings.TrimSuffix(filepath.Base(bundle), filepath.Ext(bundle))
+			bundleArgs := append([]string{"-p", bundle}, args...)
+			if mode == "unpack" {
+				bundleArgs = append(bundleArgs, "-o", filepath.Join(outputDir, name))
+			} else {
+				bundleArgs = append(bundleArgs, "-n", filepath.Join(outputDir, filepath.Base(bundle)))
+			}
+
+			reportPath := filepath.Join(reportDir, strconv.Itoa(index)+".json")
+			command := exec.Command(executable, bundleArgs...)
+			command.Env = append(os.Environ(), BATCH_REPORT_ENV+"="+reportPath)
+
+			output, err := command.CombinedOutput()
+			results[index] = BatchResult{
+				Bundle: bundle,
+				Err:    err,
+				Report: readBatchReport(reportPath),
+				Output: string(output),
+			}
+		}()
+	}
+
+	wait.Wait()
+	os.RemoveAll(reportDir)
+
+	failed := 0
+	fmt.Printf("%-40v %-8v %v\n", "Bundle", "Result", "Patches")
+	for _, result := range results {
+		status := "ok"
+		if result.Err != nil {
+			status = "failed"
+			failed++
+		}
+
+		patches := "-"
+		if mode == "patch" && result.Report != nil {
+			patches = fmt.Sprint(result.Report.Patches)
+		}
+
+		fmt.Printf("%-40v %-8v %v\n", result.Bundle, status, patches)
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("\n%v:\n%v", result.Bundle, result.Output)
+		}
+	}
+
+	if failed > 0 {
+		fail("%v of %v bundles failed.\n", failed, len(results))
+	}
+}
+
+// Read the report a run of the batch wrote, nil when it stopped before writing one
+func readBatchReport(path string) *BatchReport {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var report BatchReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return nil
+	}
+
+	return &report
+}
+
+// Write the report of this run when it's part of a batch
+func writeBatchReport() {
+	path := os.Getenv(BATCH_REPORT_ENV)
+	if path == "" {
+		return
+	}
+
+	content, err := json.Marshal(BatchReport{Patches: changingPatches})
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		fail("%v\n", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// Print a single module of the bundle, only reading its bytes
+func catModule() {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	entries, moduleStart, startupLength, err := readEntryTable(bundleFile)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	var content []byte
+	if selectedModule == "startup" {
+		content, err = readFileAtOffset(bundleFile, moduleStart, startupLength)
+		if err != nil {
+			fail("%v\n", err)
+		}
+	} else {
+		id, err := strconv.Atoi(selectedModule)
+		if err != nil || id < 0 || id >= len(entries) {
+			fail("Module %v doesn't exist.\n", selectedModule)
+		}
+
+		if entries[id].length == 0 {
+			fail("Module %v is an empty entry of the table.\n", selectedModule)
+		}
+
+		content, err = readFileAtOffset(bundleFile, moduleStart+entries[id].offset, entries[id].length)
+		if err != nil {
+			fail("%v\n", err)
+		}
+	}
+
+	// See jsbundle.StartupRegionLength for the NUL after each module
+	if len(content) > 0 && content[len(content)-1] == 0 {
+		content = content[:len(content)-1]
+	}
+
+	os.Stdout.Write(content)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// A d[i] reference, not a property like q.d[i] of another object
+var depIndexRegex = regexp.MustCompile(`(?:^|[^\w$.])d\[(\d+)\]`)
+var entryCallRegex = regexp.MustCompile(`__r\((\d+)\)`)
+
+// Get how many brackets are left open at the end of the code, negative if more are closed than opened
+func bracketDepth(content []byte) int {
+	depth := 0
+
+	for position := 0; position < len(content); {
+		switch content[position] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '"', '\'', '`', '/':
+			position = skipLiteral(content, position)
+			continue
+		}
+
+		position++
+	}
+
+	return depth
+}
+
+// Warn about the modules a patch file left with an imbalance of brackets they didn't have before
+func checkBracketBalance(modules *map[string][]byte, unpatched map[string][]byte, name string) {
+	for _, moduleID := range sortedModuleIDs(modules) {
+		before, ok := unpatched[moduleID]
+		if !ok || bytes.Equal(before, (*modules)[moduleID]) {
+			continue
+		}
+
+		// A module that stays as unbalanced as before was most likely not broken by the patch
+		beforeDepth, afterDepth := bracketDepth(before), bracketDepth((*modules)[moduleID])
+		if afterDepth != 0 && afterDepth != beforeDepth {
+			warn("%v left module %v with unbalanced brackets (%+d, was %+d), a patch may have broken its syntax.", name, moduleID, afterDepth, beforeDepth)
+		}
+	}
+}
+
+// Print a quick summary of the bundle header
+func printHeaderSummary() {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	header, err := readHeader(bundleFile)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	fmt.Printf("%v modules, %v bytes of startup code\n", header.EntryCount, header.StartupLength)
+}
+
+// Statically check that the modules of a bundle are consistent
+func checkModules(modules *map[string][]byte) {
+	violations := 0
+
+	report := func(moduleID string, message string, args ...any) {
+		violations++
+		fmt.Printf("%v: %v\n", moduleID, fmt.Sprintf(message, args...))
+	}
+
+	exists := func(id int) bool {
+		content, ok := (*modules)[strconv.Itoa(id)]
+		return ok && len(content) > 0
+	}
+
+	for _, moduleID := range sortedModuleIDs(modules) {
+		content := (*modules)[moduleID]
+		if moduleID == "startup" || len(content) == 0 {
+			continue
+		}
+
+		if string(content[:min(4, len(content))]) != "__d(" {
+			report(moduleID, "module doesn't start with a __d call")
+			continue
+		}
+
+		if findCallEnd(content, 3) == -1 {
+			report(moduleID, "module factory is not terminated")
+			continue
+		}
+
+		id, deps, ok := parseModuleFooter(content)
+		if !ok {
+			report(moduleID, "could not parse the module id and dependencies")
+			continue
+		}
+
+		if strconv.Itoa(id) != moduleID {
+			report(moduleID, "module declares id %v", id)
+		}
+
+		for _, dep := range deps {
+			if !exists(dep) {
+				report(moduleID, "dependency %v doesn't exist", dep)
+			}
+		}
+
+		for _, match := range dependencyIndexRegex(content).FindAllSubmatch(content, -1) {
+			index, _ := strconv.Atoi(string(match[1]))
+			if index >= len(deps) {
+				report(moduleID, "d[%v] is out of range of %v dependencies", index, len(deps))
+			}
+		}
+	}
+
+	entryCalls := entryCallRegex.FindAllSubmatch((*modules)["startup"], -1)
+	if len(entryCalls) == 0 {
+		report("startup", "no entry module is required")
+	}
+
+	for _, match := range entryCalls {
+		id, _ := strconv.Atoi(string(match[1]))
+		if !exists(id) {
+			report("startup", "entry module %v doesn't exist", id)
+		}
+	}
+
+	if violations > 0 {
+		fail("Found %v problems.\n", violations)
+	}
+
+	fmt.Println("No problems found.")
+}
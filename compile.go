@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// A patch of a compiled patch file
+type CompiledPatchEntry struct {
+	Find          *string  `json:"find,omitempty"`
+	Rfind         *string  `json:"rfind,omitempty"`
+	Replace       *string  `json:"replace"`
+	PathMatch     *string  `json:"pathMatch,omitempty"`
+	Modules       []string `json:"modules,omitempty"`
+	AllModules    bool     `json:"allModules,omitempty"`
+	ExpectedCount *int     `json:"expectedCount,omitempty"`
+	Count         *int     `json:"count,omitempty"`
+	Requires      *string  `json:"requires,omitempty"`
+	ExcludesIf    *string  `json:"excludesIf,omitempty"`
+	CheckApplied  bool     `json:"checkApplied,omitempty"`
+}
+
+type CompiledPatchFile struct {
+	Patches    []CompiledPatchEntry `json:"patches"`
+	Modules    *jsbundle.Imports    `json:"modules,omitempty"`
+	NewModules []NewModuleData      `json:"newModules,omitempty"`
+	DepOps     []DependencyOp       `json:"depOps,omitempty"`
+	Target     string               `json:"target,omitempty"`
+
+	RemoveModules     []string `json:"removeModules,omitempty"`
+	RemoveModulesFind []string `json:"removeModulesFind,omitempty"`
+}
+
+// Check if a non empty suffix of a is a prefix of b
+func overlapsEnd(a string, b string) bool {
+	for length := 1; length < len(a) && length < len(b); length++ {
+		if strings.HasSuffix(a, b[:length]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if two literal patches with the same replacement give the same result in one pass as one
+// after the other. Their matches can't overlap and the replacement can't make or break a match
+func canMergeLiterals(a string, b string, replace string) bool {
+	if a == "" || b == "" || replace == "" {
+		return false
+	}
+
+	if strings.Contains(a, b) || strings.Contains(b, a) || overlapsEnd(a, b) || overlapsEnd(b, a) {
+		return false
+	}
+
+	for _, find := range []string{a, b} {
+		if strings.Contains(replace, find) || strings.Contains(find, replace) || overlapsEnd(replace, find) || overlapsEnd(find, replace) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check if a patch is a plain literal replacement with nothing else changing where it applies
+func isSimpleLiteral(patch PatchData) bool {
+	return patch.Rfind == nil && patch.Replace != nil && patch.PathMatch == nil && len(patch.Modules) == 0 && patch.ExpectedCount == nil && patch.Count == nil &&
+		patch.Requires == nil && patch.ExcludesIf == nil && !patch.CheckApplied
+}
+
+// Turn a loaded patch back into its patch file form
+func compiledPatchEntry(patch PatchData) CompiledPatchEntry {
+	compiled := CompiledPatchEntry{
+		Replace:       patch.Replace,
+		PathMatch:     patch.PathMatch,
+		Modules:       patch.Modules,
+		AllModules:    patch.AllModules,
+		ExpectedCount: patch.ExpectedCount,
+		Count:         patch.Count,
+		Requires:      patch.Requires,
+		ExcludesIf:    patch.ExcludesIf,
+		CheckApplied:  patch.CheckApplied,
+	}
+
+	if patch.Rfind != nil {
+		compiled.Rfind = patch.Rfind
+	} else {
+		compiled.Find = patch.Find
+	}
+
+	return compiled
+}
+
+// Combine the runs of adjacent literal patches with the same replacement into one regex patch
+func compilePatches(info PatchInfo) CompiledPatchFile {
+	compiled := CompiledPatchFile{
+		Modules:           info.Modules,
+		NewModules:        info.NewModules,
+		DepOps:            info.DepOps,
+		Target:            info.Target,
+		RemoveModules:     info.RemoveModules,
+		RemoveModulesFind: info.RemoveModulesFind,
+	}
+
+	for index := 0; index < len(info.Patches); {
+		patch := info.Patches[index]
+
+		// Only literal patches have a find to combine
+		finds := []string{}
+		if patch.Regex == nil {
+			finds = append(finds, *patch.Find)
+		}
+
+		next := index + 1
+		for next < len(info.Patches) && isSimpleLiteral(patch) && isSimpleLiteral(info.Patches[next]) {
+			candidate := info.Patches[next]
+			if *candidate.Replace != *patch.Replace || candidate.AllModules != patch.AllModules {
+				break
+			}
+
+			safe := true
+			for _, find := range finds {
+				safe = safe && canMergeLiterals(find, *candidate.Find, *patch.Replace)
+			}
+
+			if !safe {
+				break
+			}
+
+			finds = append(finds, *candidate.Find)
+			next++
+		}
+
+		if len(finds) <= 1 {
+			logger.Printf("%v#%v left alone\n", info.Name, index)
+			compiled.Patches = append(compiled.Patches, compiledPatchEntry(patch))
+			index = next
+			continue
+		}
+
+		quoted := []string{}
+		for _, find := range finds {
+			quoted = append(quoted, regexp.QuoteMeta(find))
+		}
+
+		rfind := strings.Join(quoted, "|")
+		replace := strings.ReplaceAll(*patch.Replace, "$", "$$")
+
+		merged := compiledPatchEntry(patch)
+		merged.Find = nil
+		merged.Rfind = &rfind
+		merged.Replace = &replace
+		compiled.Patches = append(compiled.Patches, merged)
+
+		logger.Printf("%v#%v to #%v combined into one patch\n", info.Name, index, next-1)
+		index = next
+	}
+
+	return compiled
+}
+
+// Write a compiled version of every patch file of the patches folder to the output folder
+func compilePatchFolder() {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fail("%v\n", err)
+	}
+
+	for _, info := range loadPatches() {
+		content, err := json.MarshalIndent(compilePatches(info), "", "  ")
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		path := filepath.Join(outputDir, info.Name+".json")
+		if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+			fail("%v\n", err)
+		}
+	}
+}
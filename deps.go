@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+var moduleFooterRegex = regexp.MustCompile(`,\s*(\d+)\s*,\s*\[([\d,\s]*)\]\s*(?:,\s*"([^"]*)"\s*)?\)\s*;?\s*$`)
+
+// Parse the module id and dependency array from the end of a __d call
+func parseModuleFooter(content []byte) (int, []int, bool) {
+	matches := moduleFooterRegex.FindSubmatch(content)
+	if matches == nil {
+		return 0, nil, false
+	}
+
+	id, err := strconv.Atoi(string(matches[1]))
+	if err != nil {
+		return 0, nil, false
+	}
+
+	deps := []int{}
+	for _, dep := range strings.Split(string(matches[2]), ",") {
+		dep = strings.TrimSpace(dep)
+		if dep == "" {
+			continue
+		}
+
+		depID, err := strconv.Atoi(dep)
+		if err != nil {
+			return 0, nil, false
+		}
+
+		deps = append(deps, depID)
+	}
+
+	return id, deps, true
+}
+
+// Get the module path dev bundles pass as the last argument of __d, if there's one
+func moduleVerboseName(content []byte) string {
+	matches := moduleFooterRegex.FindSubmatch(content)
+	if matches == nil {
+		return ""
+	}
+
+	return string(matches[3])
+}
+
+// Skip over a string literal or comment starting at position and return the position after it
+func skipLiteral(content []byte, position int) int {
+	switch content[position] {
+	case '"', '\'', '`':
+		quote := content[position]
+		for position++; position < len(content) && content[position] != quote; position++ {
+			if content[position] == '\\' {
+				position++
+			}
+		}
+
+		return position + 1
+	case '/':
+		if position+1 < len(content) && content[position+1] == '/' {
+			for position < len(content) && content[position] != '\n' {
+				position++
+			}
+
+			return position
+		}
+
+		if position+1 < len(content) && content[position+1] == '*' {
+			end := strings.Index(string(content[position+2:]), "*/")
+			if end == -1 {
+				return len(content)
+			}
+
+			return position + 2 + end + 2
+		}
+	}
+
+	return position + 1
+}
+
+// Find the end of the call whose opening parenthesis is at position
+func findCallEnd(content []byte, position int) int {
+	depth := 0
+
+	for position < len(content) {
+		switch content[position] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return position + 1
+			}
+		case '"', '\'', '`', '/':
+			position = skipLiteral(content, position)
+			continue
+		}
+
+		position++
+	}
+
+	return -1
+}
+
+// Split the dependency array of a module into its ids
+func splitDependencies(deps string) []string {
+	ids := []string{}
+	for _, dep := range strings.Split(deps, ",") {
+		if dep = strings.TrimSpace(dep); dep != "" {
+			ids = append(ids, dep)
+		}
+	}
+
+	return ids
+}
+
+// Add a dependency at the end of a module's dependency array, returns its index and whether it was added
+func addDependency(module []byte, dep int) ([]byte, int, bool, error) {
+	location := jsbundle.ModuleRegex.FindSubmatchIndex(module)
+	if location == nil {
+		return module, 0, false, fmt.Errorf("module doesn't have a __d wrapper")
+	}
+
+	deps := splitDependencies(string(module[location[8]:location[9]]))
+	if index := slices.Index(deps, strconv.Itoa(dep)); index != -1 {
+		return module, index, false, nil
+	}
+
+	deps = append(deps, strconv.Itoa(dep))
+
+	patched := append([]byte{}, module[:location[8]]...)
+	patched = append(patched, strings.Join(deps, ",")...)
+	patched = append(patched, module[location[9]:]...)
+
+	return patched, len(deps) - 1, true, nil
+}
+
+// Find the references to the dependency map of a module, named d unless the factory renamed it
+func dependencyIndexRegex(module []byte) *regexp.Regexp {
+	arguments := jsbundle.FactoryArguments(module)
+	if len(arguments) <= jsbundle.DependencyMapArgument || arguments[jsbundle.DependencyMapArgument] == "d" {
+		return depIndexRegex
+	}
+
+	return regexp.MustCompile(`(?:^|[^\w$.])` + regexp.QuoteMeta(arguments[jsbundle.DependencyMapArgument]) + `\[(\d+)\]`)
+}
+
+// Remove a dependency from a module's dependency array, moving the d[i] references after it down
+func removeDependency(module []byte, dep int) ([]byte, int, error) {
+	location := jsbundle.ModuleRegex.FindSubmatchIndex(module)
+	if location == nil {
+		return module, 0, fmt.Errorf("module doesn't have a __d wrapper")
+	}
+
+	indexRegex := dependencyIndexRegex(module)
+
+	deps := splitDependencies(string(module[location[8]:location[9]]))
+	removed := slices.Index(deps, strconv.Itoa(dep))
+	if removed == -1 {
+		return module, 0, fmt.Errorf("%v isn't a dependency", dep)
+	}
+
+	body := module[location[4]:location[5]]
+	failed := error(nil)
+
+	body = indexRegex.ReplaceAllFunc(body, func(match []byte) []byte {
+		submatch := indexRegex.FindSubmatchIndex(match)
+		index, _ := strconv.Atoi(string(match[submatch[2]:submatch[3]]))
+		switch {
+		case index == removed:
+			failed = fmt.Errorf("the module still uses %v as d[%v]", dep, index)
+		case index > removed:
+			return []byte(fmt.Sprintf("%s%v]", match[:submatch[2]], index-1))
+		}
+
+		return match
+	})
+
+	if failed != nil {
+		return module, removed, failed
+	}
+
+	deps = slices.Delete(deps, removed, removed+1)
+
+	patched := append([]byte{}, module[:location[4]]...)
+	patched = append(patched, body...)
+	patched = append(patched, module[location[5]:location[8]]...)
+	patched = append(patched, strings.Join(deps, ",")...)
+	patched = append(patched, module[location[9]:]...)
+
+	return patched, removed, nil
+}
+
+// Apply the dependency array edits of a patch file
+func applyDependencyOps(modules *map[string][]byte, info PatchInfo) {
+	for _, op := range info.DepOps {
+		moduleID := strconv.Itoa(op.Module)
+		module, ok := (*modules)[moduleID]
+		if !ok || len(module) == 0 {
+			fail("Module %v edited by %v doesn't exist.\n", moduleID, info.Name)
+		}
+
+		if op.AddDep != nil {
+			if _, ok := (*modules)[strconv.Itoa(*op.AddDep)]; !ok {
+				fail("Module %v added as a dependency by %v doesn't exist.\n", *op.AddDep, info.Name)
+			}
+
+			patched, index, added, err := addDependency(module, *op.AddDep)
+			if err != nil {
+				fail("Could not add dependency %v to module %v for %v: %v.\n", *op.AddDep, moduleID, info.Name, err)
+			}
+
+			module = patched
+			if added {
+				logger.Printf("Module %v depends on %v as d[%v]\n", moduleID, *op.AddDep, index)
+			} else {
+				logger.Printf("Module %v already depends on %v as d[%v]\n", moduleID, *op.AddDep, index)
+			}
+		}
+
+		if op.RemoveDep != nil {
+			patched, index, err := removeDependency(module, *op.RemoveDep)
+			if err != nil {
+				fail("Could not remove dependency %v from module %v for %v: %v.\n", *op.RemoveDep, moduleID, info.Name, err)
+			}
+
+			module = patched
+			logger.Printf("Module %v no longer depends on %v, the dependencies after d[%v] moved down one index\n", moduleID, *op.RemoveDep, index)
+		}
+
+		(*modules)[moduleID] = module
+	}
+}
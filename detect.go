@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// Guess the format of a file from its first bytes
+func guessFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return "zip"
+	}
+
+	format, _ := jsbundle.DetectFormat(bytes.NewReader(data))
+	return format.String()
+}
+
+// Say whether jsbundletools can read a file of the guessed format
+func formatSupport(format string) string {
+	switch format {
+	case jsbundle.FormatHermes.String():
+		return "Hermes bytecode is compiled JavaScript, jsbundletools only reads RAM bundles and can't patch it."
+	case jsbundle.FormatPlain.String():
+		return "Plain bundles aren't supported by this mode, convert it into a RAM bundle with -m split first."
+	case "gzip", "zip":
+		return fmt.Sprintf("Extract the bundle from the %v archive first.", format)
+	}
+
+	return "jsbundletools only reads RAM bundles."
+}
+
+// Describe the first bytes of a file that doesn't have the magic number
+func describeHeader(r io.ReaderAt) string {
+	data := make([]byte, 16)
+	n, _ := r.ReadAt(data, 0)
+	data = data[:n]
+
+	description := fmt.Sprintf("First %v bytes: % x\n", len(data), data)
+
+	if len(data) >= UINT32_LENGTH {
+		description += fmt.Sprintf("As little endian: 0x%08x, as big endian: 0x%08x\n", binary.LittleEndian.Uint32(data), binary.BigEndian.Uint32(data))
+	}
+
+	format := guessFormat(data)
+	return description + fmt.Sprintf("This looks like: %v. %v", format, formatSupport(format))
+}
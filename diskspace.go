@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Exit before writing a file of length bytes to path if the volume doesn't have room for it
+func checkDiskSpace(path string, length int) {
+	// Stdout is buffered in memory, not on a volume
+	if path == "-" {
+		return
+	}
+
+	available, ok := availableDiskSpace(filepath.Dir(path))
+	if !ok {
+		return
+	}
+
+	// The file being replaced frees its space
+	if info, err := os.Stat(path); err == nil {
+		available += uint64(info.Size())
+	}
+
+	if uint64(length) > available {
+		fail("%v needs %v bytes but only %v are available.\n", path, length, available)
+	}
+}
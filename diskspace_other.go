@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// Free space isn't checked on this platform
+func availableDiskSpace(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// Get the bytes available to the user on the volume holding path
+func availableDiskSpace(path string) (uint64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, false
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+)
+
+type PatchMatches struct {
+	Patch   string
+	Modules []string
+	Counts  map[string]int
+}
+
+var patchMatches = []*PatchMatches{}
+
+// Start counting the matches of every patch of a patch file, so the ones matching nothing are reported too
+func registerPatchMatches(info PatchInfo) {
+	if !dryRun {
+		return
+	}
+
+	for index := range info.Patches {
+		patchMatches = append(patchMatches, &PatchMatches{Patch: fmt.Sprintf("%v#%v", info.Name, index), Counts: map[string]int{}})
+	}
+}
+
+// Add the replacements a patch made in a module
+func recordPatchMatches(name string, index int, moduleID string, count int) {
+	if !dryRun || count == 0 {
+		return
+	}
+
+	for _, matches := range patchMatches {
+		if matches.Patch != fmt.Sprintf("%v#%v", name, index) {
+			continue
+		}
+
+		if _, ok := matches.Counts[moduleID]; !ok {
+			matches.Modules = append(matches.Modules, moduleID)
+		}
+
+		matches.Counts[moduleID] += count
+	}
+}
+
+// Print the modules every patch would change and how many replacements it would make in each
+func printPatchMatches() {
+	fmt.Println("Dry run, nothing was written:")
+
+	for _, matches := range patchMatches {
+		total := 0
+		for _, count := range matches.Counts {
+			total += count
+		}
+
+		if len(matches.Modules) == 0 {
+			fmt.Printf("%v: no replacements\n", matches.Patch)
+			continue
+		}
+
+		fmt.Printf("%v: %v replacements in %v modules\n", matches.Patch, total, len(matches.Modules))
+		for _, moduleID := range matches.Modules {
+			fmt.Printf("    %-8v %v\n", moduleID, matches.Counts[moduleID])
+		}
+	}
+}
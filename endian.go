@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// The byte order packed bundles are written in, the one of the input bundle or the unpacked folder
+// so a repack keeps it
+var byteOrder binary.ByteOrder = binary.LittleEndian
+
+// Name a byte order the way info and the manifest show it
+func byteOrderName(order binary.ByteOrder) string {
+	if order == binary.BigEndian {
+		return "big endian"
+	}
+
+	return "little endian"
+}
+
+// Read the byte order of the bundle at path from its magic number
+func readBundleByteOrder(path string) (binary.ByteOrder, error) {
+	bundleFile, err := openBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer bundleFile.Close()
+
+	header, err := readHeader(bundleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return header.ByteOrder, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// Metro calls __r, or require in older versions, with the modules to run before the main module first,
+// so the entry point is the last call
+var entryRewriteRegex = regexp.MustCompile(`(?:\b__r|\brequire)\(\s*(\d+)\s*\)`)
+
+// Rewrite the entry call of the startup code to require moduleID instead
+func setEntryModule(modules *map[string][]byte, moduleID int) {
+	if len((*modules)[strconv.Itoa(moduleID)]) == 0 {
+		fail("Entry module %v doesn't exist.\n", moduleID)
+	}
+
+	startup := (*modules)["startup"]
+	calls := entryRewriteRegex.FindAllSubmatchIndex(startup, -1)
+	if len(calls) == 0 {
+		fail("Could not find the entry call of the startup code.\n")
+	}
+
+	call := calls[len(calls)-1]
+	previous := string(startup[call[2]:call[3]])
+
+	patched := append([]byte{}, startup[:call[2]]...)
+	patched = append(patched, strconv.Itoa(moduleID)...)
+	patched = append(patched, startup[call[3]:]...)
+	(*modules)["startup"] = patched
+
+	logger.Printf("The startup code now requires module %v instead of %v\n", moduleID, previous)
+}
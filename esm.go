@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// Get the regex matching the calls requiring a dependency by its index with the factory's own names
+// for require, importDefault, importAll and the dependency map. The character before the call is kept
+func requireCallRegex(arguments []string) *regexp.Regexp {
+	require := regexp.QuoteMeta(arguments[jsbundle.RequireArgument])
+	importDefault := regexp.QuoteMeta(arguments[jsbundle.ImportDefaultArgument])
+	importAll := regexp.QuoteMeta(arguments[jsbundle.ImportAllArgument])
+	dependencyMap := regexp.QuoteMeta(arguments[jsbundle.DependencyMapArgument])
+
+	return regexp.MustCompile(fmt.Sprintf(`(^|[^\w$.])(?:%v|%v|%v)\(\s*%v\[(\d+)\]\s*\)`, require, importDefault, importAll, dependencyMap))
+}
+
+// Rewrite a module as an ES module importing its dependencies from the unpacked files, best effort
+func moduleToESM(moduleID string, content []byte, names map[string]string) []byte {
+	matches := jsbundle.ModuleRegex.FindSubmatch(content)
+	arguments := jsbundle.FactoryArguments(content)
+	_, deps, ok := parseModuleFooter(content)
+	if matches == nil || !ok || len(arguments) <= jsbundle.DependencyMapArgument {
+		return content
+	}
+
+	imports := []string{}
+	for _, dep := range deps {
+		depID := strconv.Itoa(dep)
+		target, ok := names[depID]
+		if !ok {
+			continue
+		}
+
+		relative, err := filepath.Rel(filepath.Dir(names[moduleID]), target)
+		if err != nil {
+			continue
+		}
+
+		relative = filepath.ToSlash(relative)
+		if !strings.HasPrefix(relative, ".") {
+			relative = "./" + relative
+		}
+
+		imports = append(imports, fmt.Sprintf("import _%v from %q;", depID, relative))
+	}
+
+	requireCall := requireCallRegex(arguments)
+	body := requireCall.ReplaceAllStringFunc(string(matches[2]), func(call string) string {
+		submatches := requireCall.FindStringSubmatch(call)
+		index, _ := strconv.Atoi(submatches[2])
+		if index >= len(deps) {
+			return call
+		}
+
+		return fmt.Sprintf("%v_%v", submatches[1], deps[index])
+	})
+
+	module, exports := arguments[jsbundle.ModuleArgument], arguments[jsbundle.ExportsArgument]
+	lines := append(imports,
+		fmt.Sprintf("var %v = { exports: {} }, %v = %v.exports;", module, exports, module),
+		body,
+		fmt.Sprintf("export default %v.exports;", module),
+	)
+
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestModuleToESMUsesTheFactoryNames(t *testing.T) {
+	names := map[string]string{"3": "3.js", "5": "5.js", "9": "lib/9.js"}
+
+	tests := []struct {
+		name   string
+		module string
+		want   string
+	}{
+		{
+			"metro names",
+			"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])+i(d[1])},3,[5,9])",
+			"import _5 from \"./5.js\";\nimport _9 from \"./lib/9.js\";\nvar m = { exports: {} }, e = m.exports;\nm.exports=_5+_9\nexport default m.exports;\n",
+		},
+		{
+			"minified names",
+			"__d(function(t,n,o,c,s,u,l){s.exports=n(l[0])+c(l[1]);u.r=x.n(l[0])+r(d[1])},3,[5,9])",
+			"import _5 from \"./5.js\";\nimport _9 from \"./lib/9.js\";\nvar s = { exports: {} }, u = s.exports;\ns.exports=_5+_9;u.r=x.n(l[0])+r(d[1])\nexport default s.exports;\n",
+		},
+		{
+			"$ names",
+			"__d(function(g,$r,i,a,m,e,$d){m.exports=$r($d[1])},3,[5,9])",
+			"import _5 from \"./5.js\";\nimport _9 from \"./lib/9.js\";\nvar m = { exports: {} }, e = m.exports;\nm.exports=_9\nexport default m.exports;\n",
+		},
+		{
+			"no dependency map",
+			"__d(function(g,r,i,a,m,e){m.exports=r(d[0])},3,[5])",
+			"__d(function(g,r,i,a,m,e){m.exports=r(d[0])},3,[5])",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(moduleToESM("3", []byte(test.module), names)); got != test.want {
+				t.Errorf("the module was rewritten as %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quote a patch value for an explanation, shortening the long ones
+func explainValue(value string) string {
+	if len(value) > 60 {
+		value = value[:57] + "..."
+	}
+
+	return fmt.Sprintf("%q", value)
+}
+
+// Describe a single patch entry in plain words
+func explainPatch(info PatchInfo, patch PatchData) string {
+	scope := "In every module"
+	if info.Target == "startup" {
+		scope = "In the startup code"
+	} else if len(patch.Modules) > 0 && patch.PathMatch != nil {
+		scope = fmt.Sprintf("In modules %v whose path matches %v", strings.Join(patch.Modules, ", "), explainValue(*patch.PathMatch))
+	} else if len(patch.Modules) > 0 {
+		scope = fmt.Sprintf("In modules %v", strings.Join(patch.Modules, ", "))
+	} else if patch.PathMatch != nil {
+		scope = fmt.Sprintf("In modules whose path matches %v", explainValue(*patch.PathMatch))
+	}
+
+	if patch.Requires != nil {
+		scope += fmt.Sprintf(" containing %v", explainValue(*patch.Requires))
+	}
+
+	if patch.ExcludesIf != nil {
+		if patch.Requires != nil {
+			scope += " and"
+		}
+
+		scope += fmt.Sprintf(" not containing %v", explainValue(*patch.ExcludesIf))
+	}
+
+	var find string
+	if patch.Regex != nil {
+		find = "the regex " + explainValue(*patch.Rfind)
+	} else {
+		find = explainValue(*patch.Find)
+	}
+
+	action := fmt.Sprintf("replace %v with %v", find, explainValue(*patch.Replace))
+	if patch.Regex == nil && len(*patch.Replace) > len(*patch.Find) && strings.HasPrefix(*patch.Replace, *patch.Find) {
+		action = fmt.Sprintf("append %v after %v", explainValue(strings.TrimPrefix(*patch.Replace, *patch.Find)), find)
+	}
+
+	if patch.Count != nil && *patch.Count >= 0 {
+		action += fmt.Sprintf(" for the first %v occurrences of each module", *patch.Count)
+	}
+
+	switch {
+	case patch.ExpectedCount != nil && len(patch.Modules) > 0:
+		action += fmt.Sprintf(", only if there are exactly %v occurrences in these modules", *patch.ExpectedCount)
+	case patch.ExpectedCount != nil:
+		action += fmt.Sprintf(", only if there are exactly %v occurrences in the whole bundle", *patch.ExpectedCount)
+	case patch.AllModules:
+		action += ", in as many modules as it matches"
+	case info.Target != "startup":
+		action += ", warning if more than one module matches"
+	}
+
+	return fmt.Sprintf("%v, %v.", scope, action)
+}
+
+// Print what the patches of a suite do, without touching a bundle
+func explainPatches(patches []PatchInfo) {
+	for _, info := range patches {
+		fmt.Printf("%v:\n", info.Name)
+
+		if info.Modules != nil {
+			for _, moduleImportID := range info.Modules.ToImport {
+				fmt.Printf("    Modules it patches import module %v.\n", moduleImportID)
+			}
+
+			if info.Modules.Find != nil {
+				for _, moduleFind := range *info.Modules.Find {
+					fmt.Printf("    Modules it patches import the first module containing %v.\n", explainValue(moduleFind))
+				}
+			}
+		}
+
+		for _, newModule := range info.NewModules {
+			if newModule.ID != nil {
+				fmt.Printf("    Add module %v with the body %v and the dependencies %v.\n", *newModule.ID, explainValue(newModule.Body), newModule.Deps)
+			} else {
+				fmt.Printf("    Add a module with the body %v and the dependencies %v.\n", explainValue(newModule.Body), newModule.Deps)
+			}
+		}
+
+		for _, moduleID := range info.RemoveModules {
+			fmt.Printf("    Remove module %v.\n", moduleID)
+		}
+
+		for _, find := range info.RemoveModulesFind {
+			fmt.Printf("    Remove every module containing %v.\n", explainValue(find))
+		}
+
+		for _, op := range info.DepOps {
+			if op.AddDep != nil {
+				fmt.Printf("    Add module %v to the dependencies of module %v.\n", *op.AddDep, op.Module)
+			}
+
+			if op.RemoveDep != nil {
+				fmt.Printf("    Remove module %v from the dependencies of module %v.\n", *op.RemoveDep, op.Module)
+			}
+		}
+
+		for index, patch := range info.Patches {
+			fmt.Printf("    #%v %v\n", index, explainPatch(info, patch))
+		}
+	}
+}
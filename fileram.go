@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The file marking a folder as the js-modules folder of a file RAM bundle, it holds the magic number
+const UNBUNDLE_FILE = "UNBUNDLE"
+
+// Check if path is the js-modules folder of a file RAM bundle, the layout Metro writes for Android
+func isFileRAMBundle(path string) bool {
+	info, err := os.Stat(filepath.Join(path, UNBUNDLE_FILE))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Find the startup code of a file RAM bundle, Metro writes it next to the js-modules folder as the
+// bundle output. It's the only .bundle file there, index.android.bundle when there are more
+func findFileRAMStartup(path string) (string, bool) {
+	parent := filepath.Dir(filepath.Clean(path))
+
+	if _, err := os.Stat(filepath.Join(parent, "index.android.bundle")); err == nil {
+		return filepath.Join(parent, "index.android.bundle"), true
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(parent, "*.bundle"))
+	if len(matches) != 1 {
+		return "", false
+	}
+
+	return matches[0], true
+}
+
+// Read the modules of a file RAM bundle into the same map an indexed bundle gives, the ids without
+// a file are empty entries
+func readModulesFromFileRAMBundle(path string) (*map[string][]byte, error) {
+	magic, err := os.ReadFile(filepath.Join(path, UNBUNDLE_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(magic) < UINT32_LENGTH || binary.LittleEndian.Uint32(magic) != 0xfb0bd1e5 {
+		return nil, fmt.Errorf("%v doesn't hold the RAM bundle magic number", filepath.Join(path, UNBUNDLE_FILE))
+	}
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := map[string][]byte{}
+	entryCount := 0
+
+	for _, file := range files {
+		id, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".js"))
+		if err != nil || id < 0 || !strings.HasSuffix(file.Name(), ".js") || file.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(path, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		modules[strconv.Itoa(id)] = content
+		entryCount = max(entryCount, id+1)
+	}
+
+	for id := 0; id < entryCount; id++ {
+		if _, ok := modules[strconv.Itoa(id)]; !ok {
+			modules[strconv.Itoa(id)] = []byte{}
+		}
+	}
+
+	modules["startup"] = []byte{}
+	if startupPath, ok := findFileRAMStartup(path); ok {
+		startup, err := os.ReadFile(startupPath)
+		if err != nil {
+			return nil, err
+		}
+
+		modules["startup"] = startup
+	} else {
+		warn("the startup code of %v wasn't found, it should be the only .bundle file next to the folder.", path)
+	}
+
+	return &modules, nil
+}
+
+// Write the modules as a file RAM bundle, the startup code to the output file and every module to
+// the js-modules folder next to it. Numbered files left in the folder by an earlier pack are removed
+func writeFileRAMBundle(modules *map[string][]byte) error {
+	modulesDir := filepath.Join(filepath.Dir(outputFilename), "js-modules")
+	ids := slices.DeleteFunc(sortedModuleIDs(modules), func(id string) bool {
+		return id == "startup"
+	})
+
+	if dryRun {
+		fmt.Printf("Would write the startup code to %v and %v modules to %v\n", outputFilename, len(ids), modulesDir)
+		return nil
+	}
+
+	if isFileRAMBundle(modulesDir) {
+		files, err := os.ReadDir(modulesDir)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			id, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".js"))
+			if err != nil || !strings.HasSuffix(file.Name(), ".js") {
+				continue
+			}
+
+			if len((*modules)[strconv.Itoa(id)]) == 0 {
+				if err := os.Remove(filepath.Join(modulesDir, file.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	writer := &dirWriter{root: modulesDir}
+
+	magic := binary.LittleEndian.AppendUint32(nil, 0xfb0bd1e5)
+	if err := writer.WriteFile(UNBUNDLE_FILE, magic); err != nil {
+		return err
+	}
+
+	// Empty modules are the holes of the table, they don't get a file
+	written := 0
+	for _, id := range ids {
+		if len((*modules)[id]) == 0 {
+			continue
+		}
+
+		if err := writer.WriteFile(id+".js", (*modules)[id]); err != nil {
+			return err
+		}
+
+		written++
+	}
+
+	if len((*modules)["startup"]) == 0 {
+		warn("the bundle has no startup code, the runtime won't require any module by itself.")
+	}
+
+	if err := (&dirWriter{root: filepath.Dir(outputFilename)}).WriteFile(filepath.Base(outputFilename), (*modules)["startup"]); err != nil {
+		return err
+	}
+
+	logger.Printf("File RAM bundle has been created with %v modules in %v\n", written, modulesDir)
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Check if a module is within the -min-size and -max-size limits
+func moduleSizeMatches(content []byte) bool {
+	return len(content) >= minSize && (maxSize < 0 || len(content) <= maxSize)
+}
+
+// List the modules within the size limits, biggest first
+func printModuleSizes(modules *map[string][]byte) {
+	modulePaths := readModulePaths()
+
+	ids := []string{}
+	total := 0
+	for _, moduleID := range sortedModuleIDs(modules) {
+		if moduleID != "startup" && moduleSizeMatches((*modules)[moduleID]) {
+			ids = append(ids, moduleID)
+			total += len((*modules)[moduleID])
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return len((*modules)[ids[i]]) > len((*modules)[ids[j]])
+	})
+
+	fmt.Printf("%v modules within the size limits, %v bytes\n", len(ids), total)
+
+	if top > 0 && len(ids) > top {
+		ids = ids[:top]
+	}
+
+	fmt.Printf("%-8v %-10v %v\n", "Module", "Size", "Path")
+	for _, moduleID := range ids {
+		path, ok := modulePaths[moduleID]
+		if !ok {
+			path = moduleVerboseName((*modules)[moduleID])
+		}
+
+		fmt.Printf("%-8v %-10v %v\n", moduleID, len((*modules)[moduleID]), path)
+	}
+}
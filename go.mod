@@ -0,0 +1,3 @@
+module github.com/NotZoeyDev/jsbundletools
+
+go 1.23
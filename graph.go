@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path"
+	"strconv"
+)
+
+// Parse the dependencies of every module
+func moduleDependencies(modules *map[string][]byte) map[string][]int {
+	dependencies := map[string][]int{}
+
+	for moduleID, content := range *modules {
+		if moduleID == "startup" {
+			continue
+		}
+
+		if _, deps, ok := parseModuleFooter(content); ok {
+			dependencies[moduleID] = deps
+		}
+	}
+
+	return dependencies
+}
+
+// Find the modules required by the startup code
+func entryModules(modules *map[string][]byte) []int {
+	entries := []int{}
+
+	for _, match := range entryCallRegex.FindAllSubmatch((*modules)["startup"], -1) {
+		id, _ := strconv.Atoi(string(match[1]))
+		entries = append(entries, id)
+	}
+
+	return entries
+}
+
+// Find the module that first imports each module
+func firstImporters(modules *map[string][]byte) map[string]string {
+	importers, _, _ := walkFromEntries(modules)
+	return importers
+}
+
+// Find the modules that can be required starting from the startup code
+func reachableModules(modules *map[string][]byte) map[string]bool {
+	_, reachable, _ := walkFromEntries(modules)
+	return reachable
+}
+
+// Find the order the modules are likely loaded in, the reachable ones by distance from the startup
+// code then the others in id order
+func accessOrder(modules *map[string][]byte) []string {
+	_, visited, order := walkFromEntries(modules)
+
+	for _, moduleID := range sortedModuleIDs(modules) {
+		if moduleID != "startup" && !visited[moduleID] {
+			order = append(order, moduleID)
+		}
+	}
+
+	return order
+}
+
+// Walk the graph from the entry modules, returns the first importer of each module, the visited
+// modules and the order they were visited in
+func walkFromEntries(modules *map[string][]byte) (map[string]string, map[string]bool, []string) {
+	dependencies := moduleDependencies(modules)
+	importers := map[string]string{}
+	visited := map[string]bool{}
+	queue := []string{}
+	order := []string{}
+
+	// Calls and dependencies of modules that aren't in the bundle can't load anything
+	exists := func(moduleID string) bool {
+		return len((*modules)[moduleID]) > 0 && moduleID != "startup"
+	}
+
+	for _, id := range entryModules(modules) {
+		if exists(strconv.Itoa(id)) && !visited[strconv.Itoa(id)] {
+			visited[strconv.Itoa(id)] = true
+			queue = append(queue, strconv.Itoa(id))
+		}
+	}
+
+	for len(queue) > 0 {
+		moduleID := queue[0]
+		queue = queue[1:]
+		order = append(order, moduleID)
+
+		for _, dep := range dependencies[moduleID] {
+			depID := strconv.Itoa(dep)
+			if visited[depID] || !exists(depID) {
+				continue
+			}
+
+			visited[depID] = true
+			importers[depID] = moduleID
+			queue = append(queue, depID)
+		}
+	}
+
+	return importers, visited, order
+}
+
+// Prefix each file name with the folders of the modules importing it
+func graphLayout(modules *map[string][]byte, names map[string]string) map[string]string {
+	importers := firstImporters(modules)
+	layout := map[string]string{}
+
+	for moduleID, name := range names {
+		folders := []string{}
+		for importer, ok := importers[moduleID]; ok; importer, ok = importers[importer] {
+			folders = append([]string{importer}, folders...)
+		}
+
+		layout[moduleID] = path.Join(append(folders, name)...)
+	}
+
+	return layout
+}
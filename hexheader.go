@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const HEX_HEADER_LENGTH = 64
+
+// Name the uint32 of the header layout at offset, the table ends at tableEnd
+func headerFieldName(offset int, tableEnd int) string {
+	if offset >= tableEnd {
+		return "module data"
+	}
+
+	switch offset {
+	case 0:
+		return "magic"
+	case UINT32_LENGTH:
+		return "entry count"
+	case UINT32_LENGTH * 2:
+		return "startup length"
+	}
+
+	index := (offset - UINT32_LENGTH*3) / (UINT32_LENGTH * 2)
+	if (offset-UINT32_LENGTH*3)%(UINT32_LENGTH*2) == 0 {
+		return fmt.Sprintf("entry %v offset", index)
+	}
+
+	return fmt.Sprintf("entry %v length", index)
+}
+
+// Print the first bytes of the bundle as hex labeled with the header fields, without parsing it
+func printHexHeader() {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	if bundleFile.Size() == 0 {
+		fmt.Println("The file is empty.")
+		return
+	}
+
+	data, err := readFileAtOffset(bundleFile, 0, int(min(bundleFile.Size(), HEX_HEADER_LENGTH)))
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	// The table size is only trusted when the magic is there, in either byte order
+	tableEnd := len(data)
+	var order binary.ByteOrder = binary.LittleEndian
+	if len(data) >= UINT32_LENGTH*2 && binary.BigEndian.Uint32(data) == 0xfb0bd1e5 {
+		order = binary.BigEndian
+	}
+
+	if len(data) >= UINT32_LENGTH*2 && order.Uint32(data) == 0xfb0bd1e5 {
+		tableEnd = UINT32_LENGTH*3 + int(order.Uint32(data[UINT32_LENGTH:]))*UINT32_LENGTH*2
+		fmt.Printf("%v bytes, looks like a %v RAM bundle\n", bundleFile.Size(), byteOrderName(order))
+	} else {
+		fmt.Printf("%v bytes, looks like %v\n", bundleFile.Size(), guessFormat(data))
+	}
+
+	for offset := 0; offset < len(data); offset += UINT32_LENGTH {
+		field := data[offset:min(offset+UINT32_LENGTH, len(data))]
+
+		hex := []string{}
+		for _, b := range field {
+			hex = append(hex, fmt.Sprintf("%02x", b))
+		}
+
+		if offset >= tableEnd {
+			fmt.Printf("%08x  %-11v  %v %q\n", offset, strings.Join(hex, " "), headerFieldName(offset, tableEnd), field)
+			continue
+		}
+
+		if len(field) < UINT32_LENGTH {
+			fmt.Printf("%08x  %-11v  %v (truncated)\n", offset, strings.Join(hex, " "), headerFieldName(offset, tableEnd))
+			continue
+		}
+
+		value := order.Uint32(field)
+		if offset == 0 {
+			fmt.Printf("%08x  %-11v  %v = 0x%08x (0xfb0bd1e5 expected)\n", offset, strings.Join(hex, " "), headerFieldName(offset, tableEnd), value)
+			continue
+		}
+
+		fmt.Printf("%08x  %-11v  %v = %v\n", offset, strings.Join(hex, " "), headerFieldName(offset, tableEnd), value)
+	}
+}
@@ -0,0 +1,320 @@
+package jsbundle
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Modules is what the patches are applied to, the startup code has the "startup" id
+type Modules interface {
+	Module(id string) []byte
+	SetModule(id string, code []byte)
+	Path(id string) (string, bool)
+}
+
+// PatchFile is a patch file ready to be applied, with its patches compiled and its imports resolved
+type PatchFile struct {
+	Name    string
+	Target  string
+	Patches []CompiledPatch
+	Imports []string
+}
+
+// PatchOptions change how ApplyPatchFile runs the patches
+type PatchOptions struct {
+	// How many modules are patched at once, one when it's lower
+	Jobs int
+
+	// Run is called with the work of a patch on a module, to time it or give up on it. It has to run
+	// step and wait for it or stop the program. A nil Run calls step directly
+	Run func(patch int, id string, step func())
+
+	// Run in order on the startup code and every module of ids once the patches are applied
+	Transforms []Transform
+}
+
+// Report is what the patches of a patch file did
+type Report struct {
+	Patches []PatchReport
+
+	// The imports that weren't injected since the module already had them
+	SkippedImports int
+
+	// The modules that couldn't be given the imports since their factory has no dependency map
+	// argument, they were patched without them
+	ImportFailed []string
+
+	// What the transforms of the options did, in their order
+	Transforms []TransformReport
+}
+
+// PatchReport is what one patch did. Matched has the modules it changed in the order of the ids,
+// with their changes in Changes
+type PatchReport struct {
+	Matched        []string
+	Changes        map[string][]Change
+	Replacements   int
+	GuardSkipped   int
+	AlreadyApplied int
+}
+
+// What the patches of a patch file did to one module, merged in id order once every module is done
+type moduleResult struct {
+	code           []byte
+	matched        []bool
+	guardSkipped   []bool
+	alreadyApplied []bool
+	changes        [][]Change
+	skippedImports int
+	importFailed   bool
+	injected       bool
+	err            error
+}
+
+// The modules changed by a patch file, they're only given to the Modules once every patch of the
+// file succeeded
+type stagedModules struct {
+	Modules
+	changed map[string][]byte
+}
+
+// ApplyPatchFile applies the patches of a file in order to the modules of ids, or only to the startup
+// code when it's the target. The imports are injected into a module before the first patch changing
+// it. Each patch sees the changes of the ones before it. A patch with an expected count is applied to
+// every module at once, and is an error when it doesn't find exactly that many occurrences. The other
+// patches run on Jobs modules at a time, the Transforms after them. The modules are only changed when
+// every patch and transform succeeds
+func ApplyPatchFile(modules Modules, ids []string, file PatchFile, options PatchOptions) (Report, error) {
+	m := &stagedModules{Modules: modules, changed: map[string][]byte{}}
+	transformed := ids
+	report := Report{Patches: make([]PatchReport, len(file.Patches))}
+	for index := range report.Patches {
+		report.Patches[index].Changes = map[string][]Change{}
+	}
+
+	if err := CheckTarget(file.Target, len(file.Imports) > 0); err != nil {
+		return report, fmt.Errorf("patch %v can't be applied, %w", file.Name, err)
+	}
+
+	// Patches targeting the startup code don't need to look at the modules
+	if file.Target == "startup" {
+		ids = []string{"startup"}
+	}
+
+	if options.Run == nil {
+		options.Run = func(patch int, id string, step func()) {
+			step()
+		}
+	}
+
+	injected := make([]bool, len(ids))
+	for first := 0; first < len(file.Patches); {
+		end := first
+		for end < len(file.Patches) && file.Patches[end].ExpectedCount == nil {
+			end++
+		}
+
+		results := patchModules(m, ids, file, first, end, injected, options)
+		for _, result := range results {
+			if result.err != nil {
+				return report, result.err
+			}
+		}
+
+		for index, result := range results {
+			id := ids[index]
+			m.SetModule(id, result.code)
+			injected[index] = result.injected
+			report.SkippedImports += result.skippedImports
+
+			if result.importFailed {
+				report.ImportFailed = append(report.ImportFailed, id)
+			}
+
+			for patch := first; patch < end; patch++ {
+				patchReport := &report.Patches[patch]
+
+				if result.alreadyApplied[patch] {
+					patchReport.AlreadyApplied++
+				}
+
+				if result.guardSkipped[patch] {
+					patchReport.GuardSkipped++
+				}
+
+				if result.matched[patch] {
+					patchReport.Matched = append(patchReport.Matched, id)
+					patchReport.Changes[id] = result.changes[patch]
+					patchReport.Replacements += len(result.changes[patch])
+				}
+			}
+		}
+
+		if end < len(file.Patches) {
+			if err := applyCountedPatch(m, ids, file, end, &report.Patches[end], options); err != nil {
+				return report, err
+			}
+		}
+
+		first = end + 1
+	}
+
+	var err error
+	if report.Transforms, err = ApplyTransforms(m, transformed, options.Transforms); err != nil {
+		return report, err
+	}
+
+	for _, id := range transformed {
+		if code, ok := m.changed[id]; ok {
+			modules.SetModule(id, code)
+		}
+	}
+
+	return report, nil
+}
+
+func (m *stagedModules) Module(id string) []byte {
+	if code, ok := m.changed[id]; ok {
+		return code
+	}
+
+	return m.Modules.Module(id)
+}
+
+// SetModule is never called while the workers read the modules
+func (m *stagedModules) SetModule(id string, code []byte) {
+	m.changed[id] = code
+}
+
+// Apply the patches first to end of a patch file to the modules, Jobs modules at a time. Every module
+// is patched on its own copy so the results are merged the same way whatever order the workers finish
+// in. injected tells which modules already had their imports injected by an earlier run of the file
+func patchModules(m Modules, ids []string, file PatchFile, first int, end int, injected []bool, options PatchOptions) []moduleResult {
+	results := make([]moduleResult, len(ids))
+	indexes := make(chan int)
+	var wait sync.WaitGroup
+
+	for range min(max(options.Jobs, 1), max(len(ids), 1)) {
+		wait.Add(1)
+
+		go func() {
+			defer wait.Done()
+
+			for index := range indexes {
+				results[index] = patchModule(m, ids[index], file, first, end, injected[index], options)
+			}
+		}()
+	}
+
+	for index := range ids {
+		indexes <- index
+	}
+
+	close(indexes)
+	wait.Wait()
+
+	return results
+}
+
+// Apply the patches first to end of a patch file to one module, the imports are injected before its
+// first match unless they already were
+func patchModule(m Modules, id string, file PatchFile, first int, end int, injected bool, options PatchOptions) moduleResult {
+	code := m.Module(id)
+	path, hasPath := m.Path(id)
+	result := moduleResult{
+		matched:        make([]bool, len(file.Patches)),
+		guardSkipped:   make([]bool, len(file.Patches)),
+		alreadyApplied: make([]bool, len(file.Patches)),
+		changes:        make([][]Change, len(file.Patches)),
+	}
+
+	for index := first; index < end && result.err == nil; index++ {
+		patch := file.Patches[index]
+
+		// Skip modules outside of the patch modules and path scope
+		if !patch.AppliesTo(id, path, hasPath) {
+			continue
+		}
+
+		options.Run(index, id, func() {
+			if patch.CheckApplied && patch.Applied(code) {
+				result.alreadyApplied[index] = true
+				return
+			}
+
+			if !patch.Finds(code) {
+				return
+			}
+
+			if !patch.Guards(code) {
+				result.guardSkipped[index] = true
+				return
+			}
+
+			// Only inject the imports once per module, the startup code can't import modules
+			if len(file.Imports) > 0 && !injected && id != "startup" {
+				injected = true
+
+				var err error
+				code, result.skippedImports, err = InjectImports(code, file.Imports)
+				result.importFailed = errors.Is(err, ErrNoDependencyMap)
+
+				if err != nil && !result.importFailed {
+					result.err = fmt.Errorf("patch %v can't import its modules into module %v, %w", file.Name, id, err)
+					return
+				}
+			}
+
+			result.matched[index] = true
+			code, result.changes[index] = patch.ReplaceIn(code)
+		})
+	}
+
+	result.code = code
+	result.injected = injected
+
+	return result
+}
+
+// Replace the matches of a patch with an expected count across the modules, only when the total
+// matches it. Counted patches don't import anything
+func applyCountedPatch(m Modules, ids []string, file PatchFile, index int, report *PatchReport, options PatchOptions) error {
+	patch := file.Patches[index]
+	staged := map[string][]byte{}
+	count := 0
+
+	for _, id := range ids {
+		path, hasPath := m.Path(id)
+		if !patch.AppliesTo(id, path, hasPath) {
+			continue
+		}
+
+		code := m.Module(id)
+		options.Run(index, id, func() {
+			if !patch.Guards(code) || patch.CheckApplied && patch.Applied(code) {
+				return
+			}
+
+			patched, changes := patch.ReplaceIn(code)
+			if len(changes) > 0 {
+				staged[id] = patched
+				report.Matched = append(report.Matched, id)
+				report.Changes[id] = changes
+				count += len(changes)
+			}
+		})
+	}
+
+	if count != *patch.ExpectedCount {
+		return fmt.Errorf("patch %v#%v found %v occurrences but %v were expected", file.Name, index, count, *patch.ExpectedCount)
+	}
+
+	for id, code := range staged {
+		m.SetModule(id, code)
+	}
+
+	report.Replacements = count
+
+	return nil
+}
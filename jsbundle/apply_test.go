@@ -0,0 +1,199 @@
+package jsbundle
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func stringPointer(value string) *string {
+	return &value
+}
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func TestApplyPatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    PatchInfo
+		startup string
+		module  string
+		failed  bool
+	}{
+		{
+			name:    "replace",
+			info:    PatchInfo{Patches: []Patch{{Find: stringPointer("m.exports=2"), Replace: stringPointer("m.exports=3")}}},
+			startup: "__r(0);",
+			module:  "__d(function(g,r,i,a,m,e,d){m.exports=3},2,[])",
+		},
+		{
+			name:    "imports",
+			info:    PatchInfo{Patches: []Patch{{Find: stringPointer("m.exports=2"), Replace: stringPointer("m.exports=cmod1")}}, Modules: &Imports{ToImport: []string{"0"}}},
+			startup: "__r(0);",
+			module:  "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[0]);m.exports=cmod1},2,[0])",
+		},
+		{
+			name:    "startup target",
+			info:    PatchInfo{Target: "startup", Patches: []Patch{{Find: stringPointer("__r"), Replace: stringPointer("require")}}},
+			startup: "require(0);",
+			module:  "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])",
+		},
+		{
+			name:    "expected count",
+			info:    PatchInfo{Patches: []Patch{{Find: stringPointer("m.exports="), Replace: stringPointer("module.exports="), ExpectedCount: intPointer(2)}}},
+			startup: "__r(0);",
+			module:  "__d(function(g,r,i,a,m,e,d){module.exports=2},2,[])",
+		},
+		{
+			name:    "wrong expected count",
+			info:    PatchInfo{Patches: []Patch{{Find: stringPointer("m.exports="), Replace: stringPointer("module.exports="), ExpectedCount: intPointer(3)}}},
+			startup: "__r(0);",
+			module:  "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])",
+			failed:  true,
+		},
+		{
+			name: "wrong expected count after a patch",
+			info: PatchInfo{Patches: []Patch{
+				{Find: stringPointer("m.exports=2"), Replace: stringPointer("m.exports=3")},
+				{Find: stringPointer("m.exports="), Replace: stringPointer("module.exports="), ExpectedCount: intPointer(3)},
+			}},
+			startup: "__r(0);",
+			module:  "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])",
+			failed:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := testBundle()
+			test.info.Name = "test"
+
+			_, err := bundle.ApplyPatches([]PatchInfo{test.info}, PatchOptions{})
+			if (err != nil) != test.failed {
+				t.Fatalf("the error is %v, want one: %v", err, test.failed)
+			}
+
+			if string(bundle.Startup) != test.startup {
+				t.Errorf("the startup code is %q, want %q", bundle.Startup, test.startup)
+			}
+
+			if string(bundle.Modules[2]) != test.module {
+				t.Errorf("module 2 is %q, want %q", bundle.Modules[2], test.module)
+			}
+		})
+	}
+}
+
+func TestApplyPatchFileReport(t *testing.T) {
+	bundle := testBundle()
+	bundle.Modules[1] = []byte("m.exports=1")
+
+	patches := []CompiledPatch{}
+	for _, patch := range []Patch{
+		{Find: stringPointer("m.exports="), Replace: stringPointer("m.exports=cmod1,"), AllModules: true},
+		{Find: stringPointer("cmod1,"), Replace: stringPointer("cmod1+"), Requires: stringPointer("exports=cmod1,2")},
+		{Find: stringPointer("nothing"), Replace: stringPointer("")},
+	} {
+		compiled, err := CompilePatch(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		patches = append(patches, compiled)
+	}
+
+	report, err := ApplyPatchFile(bundle, bundle.IDs(), PatchFile{Name: "test", Patches: patches, Imports: []string{"2"}}, PatchOptions{Jobs: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matched := strings.Join(report.Patches[0].Matched, ","); matched != "0,1,2" || report.Patches[0].Replacements != 3 {
+		t.Errorf("patch 0 matched %v with %v replacements, want 0,1,2 with 3", matched, report.Patches[0].Replacements)
+	}
+
+	if matched := strings.Join(report.Patches[1].Matched, ","); matched != "2" || report.Patches[1].GuardSkipped != 2 {
+		t.Errorf("patch 1 matched %v and skipped %v, want 2 and 2", matched, report.Patches[1].GuardSkipped)
+	}
+
+	if len(report.Patches[2].Matched) != 0 {
+		t.Errorf("patch 2 matched %v", report.Patches[2].Matched)
+	}
+
+	if failed := strings.Join(report.ImportFailed, ","); failed != "1" {
+		t.Errorf("the imports failed for %v, want 1", failed)
+	}
+
+	if module := string(bundle.Modules[1]); module != "m.exports=cmod1,1" {
+		t.Errorf("module 1 without a wrapper is %q", module)
+	}
+}
+
+func TestApplyPatchesTransforms(t *testing.T) {
+	runs := 0
+	appendComment := Transform{Name: "comment", Fn: func(id string, body []byte) ([]byte, error) {
+		runs++
+		return append(body, "//"...), nil
+	}}
+
+	failing := Transform{Name: "failing", Fn: func(id string, body []byte) ([]byte, error) {
+		return nil, errors.New("broken")
+	}}
+
+	patches := []PatchInfo{
+		{Name: "first", Patches: []Patch{{Find: stringPointer("m.exports=2"), Replace: stringPointer("m.exports=3")}}},
+		{Name: "second", Patches: []Patch{{Find: stringPointer("m.exports=3"), Replace: stringPointer("m.exports=4")}}},
+	}
+
+	tests := []struct {
+		name       string
+		transforms []Transform
+		module     string
+		failed     bool
+	}{
+		{
+			name:       "once after the last file",
+			transforms: []Transform{appendComment},
+			module:     "__d(function(g,r,i,a,m,e,d){m.exports=4},2,[])//",
+		},
+		{
+			name:       "failing",
+			transforms: []Transform{failing},
+			module:     "__d(function(g,r,i,a,m,e,d){m.exports=3},2,[])",
+			failed:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := testBundle()
+			runs = 0
+
+			reports, err := bundle.ApplyPatches(patches, PatchOptions{Transforms: test.transforms})
+			if (err != nil) != test.failed {
+				t.Fatalf("the error is %v, want one: %v", err, test.failed)
+			}
+
+			if string(bundle.Modules[2]) != test.module {
+				t.Errorf("module 2 is %q, want %q", bundle.Modules[2], test.module)
+			}
+
+			if test.failed {
+				return
+			}
+
+			if runs != len(bundle.IDs()) {
+				t.Errorf("the transform ran %v times, want %v", runs, len(bundle.IDs()))
+			}
+
+			if len(reports[0].Transforms) != 0 {
+				t.Errorf("the first patch file reports the transforms %v", reports[0].Transforms)
+			}
+
+			if changed := strings.Join(reports[1].Transforms[0].Changed, ","); changed != "startup,0,2" {
+				t.Errorf("the transform changed %v, want startup,0,2", changed)
+			}
+		})
+	}
+}
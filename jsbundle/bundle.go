@@ -0,0 +1,228 @@
+// Package jsbundle reads, writes and patches the Metro RAM bundles used by React Native apps
+package jsbundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic is the first uint32 of every RAM bundle
+const Magic = 0xfb0bd1e5
+
+const uint32Length = 4
+
+var ErrMagicNumber = errors.New("magic number not found")
+
+// Header is the start of a bundle. ByteOrder is the order its magic number was found in, the table
+// uses the same one
+type Header struct {
+	Magic         uint32
+	EntryCount    int
+	StartupLength int
+	ByteOrder     binary.ByteOrder
+}
+
+// Entry is the offset of a module from the end of the table and its length, NUL included
+type Entry struct {
+	Offset int
+	Length int
+}
+
+// Bundle holds the startup code and the modules indexed by id, without their NUL terminators.
+// A nil module is an empty entry of the table. A nil ByteOrder packs a little endian bundle
+type Bundle struct {
+	Startup   []byte
+	Modules   [][]byte
+	ByteOrder binary.ByteOrder
+
+	// The source path of the modules by id, for the patches with a pathMatch
+	Paths map[int]string
+}
+
+// ReadHeader reads the bundle header without reading the entry table or the modules
+func ReadHeader(r io.ReaderAt) (Header, error) {
+	buffer := make([]byte, uint32Length*3)
+	if err := readFull(r, buffer, 0); err != nil {
+		return Header{}, fmt.Errorf("could not read the bundle header: %w", err)
+	}
+
+	order := byteOrder(buffer)
+	if order == nil {
+		magic := binary.LittleEndian.Uint32(buffer)
+		return Header{Magic: magic}, fmt.Errorf("%w (found 0x%08x)", ErrMagicNumber, magic)
+	}
+
+	return Header{
+		Magic:         order.Uint32(buffer),
+		EntryCount:    int(order.Uint32(buffer[uint32Length:])),
+		StartupLength: int(order.Uint32(buffer[uint32Length*2:])),
+		ByteOrder:     order,
+	}, nil
+}
+
+// ReadEntryTable reads the header and the entry table. Module offsets are relative to ModuleStart
+func ReadEntryTable(r io.ReaderAt) (Header, []Entry, error) {
+	header, err := ReadHeader(r)
+	if err != nil {
+		return header, nil, err
+	}
+
+	// The header can claim any entry count, the table is only allocated once it's known to be there
+	if err := checkRange(r, uint32Length*3, header.EntryCount*uint32Length*2); err != nil {
+		return header, nil, fmt.Errorf("could not read the entry table of %v entries: %w", header.EntryCount, err)
+	}
+
+	table := make([]byte, header.EntryCount*uint32Length*2)
+	if err := readFull(r, table, uint32Length*3); err != nil {
+		return header, nil, fmt.Errorf("could not read the entry table of %v entries: %w", header.EntryCount, err)
+	}
+
+	entries := make([]Entry, header.EntryCount)
+	for i := range entries {
+		entries[i] = Entry{
+			Offset: int(header.ByteOrder.Uint32(table[i*uint32Length*2:])),
+			Length: int(header.ByteOrder.Uint32(table[i*uint32Length*2+uint32Length:])),
+		}
+	}
+
+	return header, entries, nil
+}
+
+// Read len(data) bytes at offset, r ending before all of them is io.ErrUnexpectedEOF. A ReaderAt can
+// return io.EOF along with the last bytes, that's not an error
+func readFull(r io.ReaderAt, data []byte, offset int) error {
+	n, err := r.ReadAt(data, int64(offset))
+	if n == len(data) {
+		return nil
+	}
+
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// Check that r has the length bytes at offset before they're allocated, with the size of r when it
+// tells it or by reading the last of them
+func checkRange(r io.ReaderAt, offset int, length int) error {
+	if length == 0 {
+		return nil
+	}
+
+	if sized, ok := r.(interface{ Size() int64 }); ok {
+		if int64(offset)+int64(length) > sized.Size() {
+			return io.ErrUnexpectedEOF
+		}
+
+		return nil
+	}
+
+	if n, err := r.ReadAt(make([]byte, 1), int64(offset+length-1)); n == 0 {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Get the order the magic number at the start of data is in, nil when it isn't there
+func byteOrder(data []byte) binary.ByteOrder {
+	switch {
+	case len(data) < uint32Length:
+		return nil
+	case binary.LittleEndian.Uint32(data) == Magic:
+		return binary.LittleEndian
+	case binary.BigEndian.Uint32(data) == Magic:
+		return binary.BigEndian
+	}
+
+	return nil
+}
+
+// StartupRegionLength gets the length of the startup region for startup code of size bytes.
+// The startup region holds the prelude, the polyfills and the entry require calls as a single
+// NUL terminated string at the start of the module data. The header's startup length counts the
+// NUL, the first module starts right after it, and the unpacked startup code doesn't have it.
+// A bundle without startup code has an empty startup region, without a NUL.
+func StartupRegionLength(size int) int {
+	if size == 0 {
+		return 0
+	}
+
+	return size + 1
+}
+
+// ModuleStart gets the position the startup code and the module offsets start from
+func ModuleStart(entryCount int) int {
+	return uint32Length*3 + entryCount*uint32Length*2
+}
+
+// Unpack reads the startup code and every module of a bundle
+func Unpack(r io.ReaderAt) (*Bundle, error) {
+	header, entries, err := ReadEntryTable(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return unpack(header, entries, func(entry Entry) ([]byte, error) {
+		return ReadModule(r, header, entry)
+	})
+}
+
+// UnpackBytes reads a bundle that is already in memory, like a mapped file. The modules are slices of
+// data capped at their end, so appending to one copies it instead of writing over the next
+func UnpackBytes(data []byte) (*Bundle, error) {
+	header, entries, err := ReadEntryTable(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return unpack(header, entries, func(entry Entry) ([]byte, error) {
+		offset := ModuleStart(header.EntryCount) + entry.Offset
+		if offset+entry.Length > len(data) {
+			return nil, fmt.Errorf("could not read %v bytes at offset %v: %w", entry.Length, offset, io.ErrUnexpectedEOF)
+		}
+
+		return trimTerminator(data[offset : offset+entry.Length : offset+entry.Length]), nil
+	})
+}
+
+// Read the startup code and the modules of the table with read
+func unpack(header Header, entries []Entry, read func(entry Entry) ([]byte, error)) (*Bundle, error) {
+	bundle := &Bundle{Modules: make([][]byte, len(entries)), ByteOrder: header.ByteOrder}
+
+	var err error
+	if bundle.Startup, err = read(Entry{Length: header.StartupLength}); err != nil {
+		return nil, fmt.Errorf("the startup code: %w", err)
+	}
+
+	for id, entry := range entries {
+		if entry.Length == 0 {
+			continue
+		}
+
+		if bundle.Modules[id], err = read(entry); err != nil {
+			return nil, fmt.Errorf("module %v: %w", id, err)
+		}
+	}
+
+	return bundle, nil
+}
+
+// Pack writes the bundle with the modules laid out in id order right after the startup code
+func (b *Bundle) Pack(w io.Writer) error {
+	packed := &buffer{}
+	if _, err := b.PackLayout(packed, Layout{}); err != nil {
+		return err
+	}
+
+	_, err := w.Write(packed.data)
+	return err
+}
@@ -0,0 +1,250 @@
+package jsbundle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testBundle() *Bundle {
+	return &Bundle{
+		Startup: []byte("__r(0);"),
+		Modules: [][]byte{
+			[]byte("__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[2])"),
+			nil,
+			[]byte("__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])"),
+		},
+	}
+}
+
+func TestPackUnpack(t *testing.T) {
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+	}{
+		{"little endian", binary.LittleEndian},
+		{"big endian", binary.BigEndian},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := testBundle()
+			bundle.ByteOrder = test.order
+
+			var packed bytes.Buffer
+			if err := bundle.Pack(&packed); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, unpack := range []func([]byte) (*Bundle, error){
+				func(data []byte) (*Bundle, error) { return Unpack(bytes.NewReader(data)) },
+				UnpackBytes,
+			} {
+				unpacked, err := unpack(packed.Bytes())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if unpacked.ByteOrder != test.order || !bytes.Equal(unpacked.Startup, bundle.Startup) || len(unpacked.Modules) != len(bundle.Modules) {
+					t.Fatalf("unpacked %+v, want %+v", unpacked, bundle)
+				}
+
+				for id := range bundle.Modules {
+					if !bytes.Equal(unpacked.Modules[id], bundle.Modules[id]) || (unpacked.Modules[id] == nil) != (bundle.Modules[id] == nil) {
+						t.Errorf("module %v is %q, want %q", id, unpacked.Modules[id], bundle.Modules[id])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackBytesCapsModules(t *testing.T) {
+	var packed bytes.Buffer
+	if err := testBundle().Pack(&packed); err != nil {
+		t.Fatal(err)
+	}
+
+	unpacked, err := UnpackBytes(packed.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = append(unpacked.Modules[0], "overwritten"...)
+	if !bytes.Equal(unpacked.Modules[2], testBundle().Modules[2]) {
+		t.Errorf("appending to module 0 wrote over module 2: %q", unpacked.Modules[2])
+	}
+}
+
+func TestPackLayout(t *testing.T) {
+	original := &buffer{}
+	if _, err := testBundle().PackLayout(original, Layout{Order: []int{2, 0}, AfterStartup: []byte("gap"), Trailing: []byte("end")}); err != nil {
+		t.Fatal(err)
+	}
+
+	header, entries, err := ReadEntryTable(bytes.NewReader(original.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entries[2].Offset >= entries[0].Offset {
+		t.Errorf("module 2 at %v isn't laid out before module 0 at %v", entries[2].Offset, entries[0].Offset)
+	}
+
+	if entries[1] != (Entry{}) {
+		t.Errorf("the empty module has the entry %+v", entries[1])
+	}
+
+	if !bytes.HasSuffix(original.data, []byte("end")) || !bytes.Contains(original.data, []byte("__r(0);\x00gap")) {
+		t.Errorf("the padding wasn't kept: %q", original.data)
+	}
+
+	tests := []struct {
+		name   string
+		layout Layout
+		same   bool
+	}{
+		{"original entries", Layout{AfterStartup: []byte("gap"), Trailing: []byte("end"), OriginalStartupLength: header.StartupLength, OriginalEntries: entries}, true},
+		{"aligned", Layout{Align: 16, AfterStartup: []byte("gap"), Trailing: []byte("end"), OriginalStartupLength: header.StartupLength, OriginalEntries: entries}, false},
+		{"id order", Layout{AfterStartup: []byte("gap"), Trailing: []byte("end")}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repacked := &buffer{}
+			length, err := testBundle().PackLayout(repacked, test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if length != len(repacked.data) {
+				t.Errorf("the length is %v but %v bytes were written", length, len(repacked.data))
+			}
+
+			if bytes.Equal(original.data, repacked.data) != test.same {
+				t.Errorf("the repacked bundle is the same as the original: %v, want %v\n%q\n%q", !test.same, test.same, original.data, repacked.data)
+			}
+
+			unpacked, err := UnpackBytes(repacked.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(unpacked.Modules[0], testBundle().Modules[0]) || !bytes.Equal(unpacked.Modules[2], testBundle().Modules[2]) {
+				t.Errorf("the modules changed: %q", unpacked.Modules)
+			}
+		})
+	}
+}
+
+func TestPlaceAlignsModules(t *testing.T) {
+	_, entries, _, err := Layout{Align: 16}.Place(7, []int{5, 0, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []int{0, 2} {
+		if (ModuleStart(3)+entries[id].Offset)%16 != 0 {
+			t.Errorf("module %v starts at %v, not on a 16 byte boundary", id, ModuleStart(3)+entries[id].Offset)
+		}
+	}
+
+	length := 4
+	if _, _, _, err := (Layout{StartupLength: &length}).Place(7, []int{5}); err != nil {
+		t.Errorf("a startup length shorter than the region is an error: %v", err)
+	}
+
+	length = 9
+	if _, _, _, err := (Layout{StartupLength: &length}).Place(7, []int{5}); err == nil {
+		t.Error("a startup length past the region isn't an error")
+	}
+}
+
+// A ReaderAt that doesn't tell its size
+type unsizedReader struct {
+	data []byte
+}
+
+func (r unsizedReader) ReadAt(p []byte, offset int64) (int, error) {
+	return bytes.NewReader(r.data).ReadAt(p, offset)
+}
+
+func TestUnpackRejectsSizesPastTheEnd(t *testing.T) {
+	table := binary.LittleEndian.AppendUint32(nil, Magic)
+	table = binary.LittleEndian.AppendUint32(table, 0xffffffff)
+	table = binary.LittleEndian.AppendUint32(table, 0)
+
+	module := binary.LittleEndian.AppendUint32(nil, Magic)
+	module = binary.LittleEndian.AppendUint32(module, 1)
+	module = binary.LittleEndian.AppendUint32(module, 0)
+	module = binary.LittleEndian.AppendUint32(module, 0)
+	module = binary.LittleEndian.AppendUint32(module, 0xfffffff0)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"entry count", append(table, make([]byte, 40)...)},
+		{"module length", append(module, make([]byte, 32)...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, r := range []io.ReaderAt{bytes.NewReader(test.data), unsizedReader{test.data}} {
+				if _, err := Unpack(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("the error is %v, want io.ErrUnexpectedEOF", err)
+				}
+			}
+
+			if _, err := UnpackBytes(test.data); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("the error of UnpackBytes is %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+// Files written by UnpackStreamTo, by name
+type memoryFiles map[string][]byte
+
+func (files memoryFiles) WriteFile(name string, data []byte) error {
+	files[name] = data
+	return nil
+}
+
+func TestUnpackTruncated(t *testing.T) {
+	var packed bytes.Buffer
+	if err := testBundle().Pack(&packed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		length int
+		want   string
+	}{
+		{"header", 6, "header"},
+		{"last module", packed.Len() - 5, "module 2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := packed.Bytes()[:test.length]
+
+			errs := []error{}
+			for _, r := range []io.ReaderAt{bytes.NewReader(data), unsizedReader{data}} {
+				_, err := Unpack(r)
+				errs = append(errs, err, UnpackStreamTo(r, memoryFiles{}))
+			}
+
+			_, err := UnpackBytes(data)
+			for _, err := range append(errs, err) {
+				if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.Contains(err.Error(), test.want) {
+					t.Errorf("the error is %v, want an unexpected EOF of the %v", err, test.want)
+				}
+			}
+		})
+	}
+}
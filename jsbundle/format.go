@@ -0,0 +1,76 @@
+package jsbundle
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// Format is the kind of bundle a file holds, as far as its first bytes tell
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatRAMBundle
+	FormatHermes
+	FormatPlain
+)
+
+// HermesMagic starts every Hermes bytecode file
+var HermesMagic = []byte{0xc6, 0x1f, 0xbc, 0x03, 0xc1, 0x03, 0x19, 0x1f}
+
+// The bytes read to tell a plain bundle from a binary file
+const formatSniffLength = 64
+
+func (f Format) String() string {
+	switch f {
+	case FormatRAMBundle:
+		return "RAM bundle"
+	case FormatHermes:
+		return "Hermes bytecode"
+	case FormatPlain:
+		return "plain JavaScript"
+	}
+
+	return "unknown"
+}
+
+// Supported reports whether the package can read the format. Plain bundles have to be split into a
+// RAM bundle first
+func (f Format) Supported() bool {
+	return f == FormatRAMBundle
+}
+
+// DetectFormat classifies a file from its first bytes. An empty file is unknown, only failed reads
+// are errors
+func DetectFormat(r io.ReaderAt) (Format, error) {
+	data := make([]byte, formatSniffLength)
+	n, err := r.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return FormatUnknown, err
+	}
+
+	return detectFormat(data[:n]), nil
+}
+
+func detectFormat(data []byte) Format {
+	switch {
+	case byteOrder(data) != nil:
+		return FormatRAMBundle
+	case bytes.HasPrefix(data, HermesMagic):
+		return FormatHermes
+	case len(data) > 0 && !bytes.ContainsRune(data, 0) && utf8.Valid(trimPartialRune(data)):
+		return FormatPlain
+	}
+
+	return FormatUnknown
+}
+
+// Drop the bytes of a character cut at the end of data, so only what was read is judged
+func trimPartialRune(data []byte) []byte {
+	for cut := 0; cut < utf8.UTFMax-1 && len(data) > 0 && !utf8.Valid(data); cut++ {
+		data = data[:len(data)-1]
+	}
+
+	return data
+}
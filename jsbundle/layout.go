@@ -0,0 +1,280 @@
+package jsbundle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Layout is where Pack puts the startup code and the modules. The zero value lays the modules out in
+// id order right after the startup code
+type Layout struct {
+	// The ids in the order their data is laid out, the ones missing go after them in id order. The
+	// table is always in id order
+	Order []int
+
+	// Start every module on a boundary of this many bytes of the file, 0 or 1 for no alignment
+	Align int
+
+	// The bytes kept between the startup code and the first module and after the last module
+	AfterStartup []byte
+	Trailing     []byte
+
+	// The startup length written in the header instead of the length of the startup region, it can
+	// be shorter but never past it
+	StartupLength *int
+
+	// The startup length and the entries of the bundle the modules were read from. While every
+	// module keeps its size and nothing overlaps the modules go back where they were, without
+	// alignment or a startup length
+	OriginalStartupLength int
+	OriginalEntries       []Entry
+
+	// The original startup region and the original modules by id that had no NUL, they go back
+	// without one while the original entries are used
+	OriginalUnterminatedStartup bool
+	OriginalUnterminated        []bool
+}
+
+// Place gets the startup length of the header, the entry table and the length of a bundle whose
+// startup code and modules have these sizes, without their NUL. A zero size is an empty entry
+func (l Layout) Place(startupSize int, sizes []int) (int, []Entry, int, error) {
+	startupLength := StartupRegionLength(startupSize)
+	if l.StartupLength != nil {
+		if *l.StartupLength > startupLength {
+			return 0, nil, 0, fmt.Errorf("the startup length %v is past the %v bytes of the startup region", *l.StartupLength, startupLength)
+		}
+
+		startupLength = *l.StartupLength
+	}
+
+	if entries, length, ok := l.original(startupSize, sizes); ok {
+		return l.OriginalStartupLength, entries, length, nil
+	}
+
+	moduleStart := ModuleStart(len(sizes))
+	entries := make([]Entry, len(sizes))
+	offset := StartupRegionLength(startupSize) + len(l.AfterStartup)
+
+	for _, id := range l.order(len(sizes)) {
+		// Empty modules are holes in the table, written with a zero offset and length like Metro does
+		if sizes[id] == 0 {
+			continue
+		}
+
+		if remainder := (moduleStart + offset) % max(l.Align, 1); remainder != 0 {
+			offset += l.Align - remainder
+		}
+
+		entries[id] = Entry{Offset: offset, Length: sizes[id] + 1}
+		offset += entries[id].Length
+	}
+
+	return startupLength, entries, moduleStart + offset + len(l.Trailing), nil
+}
+
+// StartupRegion gets the length of the startup region Place lays out for startup code and modules of
+// these sizes, the AfterStartup bytes start right after it
+func (l Layout) StartupRegion(startupSize int, sizes []int) int {
+	if _, _, ok := l.original(startupSize, sizes); ok {
+		return l.OriginalStartupLength
+	}
+
+	return StartupRegionLength(startupSize)
+}
+
+// Get the ids in the order their data is laid out
+func (l Layout) order(count int) []int {
+	order := []int{}
+	placed := make([]bool, count)
+
+	for _, id := range l.Order {
+		if id >= 0 && id < count && !placed[id] {
+			placed[id] = true
+			order = append(order, id)
+		}
+	}
+
+	for id := range count {
+		if !placed[id] {
+			order = append(order, id)
+		}
+	}
+
+	return order
+}
+
+// Get the entries of the original bundle when every module still fits where it was
+func (l Layout) original(startupSize int, sizes []int) ([]Entry, int, bool) {
+	if l.OriginalEntries == nil || l.Align > 1 || l.StartupLength != nil || len(l.OriginalEntries) != len(sizes) {
+		return nil, 0, false
+	}
+
+	startupRegion := StartupRegionLength(startupSize)
+	if l.OriginalUnterminatedStartup {
+		startupRegion = startupSize
+	}
+
+	if startupRegion != l.OriginalStartupLength {
+		return nil, 0, false
+	}
+
+	entries := make([]Entry, len(sizes))
+	ordered := []Entry{}
+	end := l.OriginalStartupLength + len(l.AfterStartup)
+
+	for id, original := range l.OriginalEntries {
+		if original.Length == 0 {
+			if sizes[id] != 0 {
+				return nil, 0, false
+			}
+
+			continue
+		}
+
+		size := sizes[id] + 1
+		if id < len(l.OriginalUnterminated) && l.OriginalUnterminated[id] {
+			size = sizes[id]
+		}
+
+		if size != original.Length {
+			return nil, 0, false
+		}
+
+		entries[id] = original
+		ordered = append(ordered, original)
+		end = max(end, original.Offset+original.Length)
+	}
+
+	// Modules sharing bytes can't be written back separately
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].Offset < ordered[j].Offset
+	})
+
+	previousEnd := l.OriginalStartupLength + len(l.AfterStartup)
+	for _, entry := range ordered {
+		if entry.Offset < previousEnd {
+			return nil, 0, false
+		}
+
+		previousEnd = entry.Offset + entry.Length
+	}
+
+	return entries, ModuleStart(len(sizes)) + end + len(l.Trailing), true
+}
+
+// AppendHeader appends the magic number, the entry count, the startup length and the entry table to
+// data, in order. A nil order is little endian
+func AppendHeader(data []byte, order binary.ByteOrder, startupLength int, entries []Entry) []byte {
+	var appender binary.AppendByteOrder = binary.LittleEndian
+	if order == binary.BigEndian {
+		appender = binary.BigEndian
+	}
+
+	data = appender.AppendUint32(data, Magic)
+	data = appender.AppendUint32(data, uint32(len(entries)))
+	data = appender.AppendUint32(data, uint32(startupLength))
+
+	for _, entry := range entries {
+		data = appender.AppendUint32(data, uint32(entry.Offset))
+		data = appender.AppendUint32(data, uint32(entry.Length))
+	}
+
+	return data
+}
+
+// Place gets the startup length of the header, the entry table and the length of the bundle laid
+// out by layout
+func (b *Bundle) Place(layout Layout) (int, []Entry, int, error) {
+	return layout.Place(len(b.Startup), b.sizes())
+}
+
+// Get the sizes of the modules
+func (b *Bundle) sizes() []int {
+	sizes := make([]int, len(b.Modules))
+	for id, module := range b.Modules {
+		sizes[id] = len(module)
+	}
+
+	return sizes
+}
+
+// PackLayout writes the bundle to w laid out by layout and returns its length. Every byte up to
+// the length is written, the gaps between the modules as zeros
+func (b *Bundle) PackLayout(w io.WriterAt, layout Layout) (int, error) {
+	startupLength, entries, length, err := b.Place(layout)
+	if err != nil {
+		return 0, err
+	}
+
+	startupRegion := layout.StartupRegion(len(b.Startup), b.sizes())
+
+	moduleStart := ModuleStart(len(entries))
+	if _, err := w.WriteAt(AppendHeader(nil, b.ByteOrder, startupLength, entries), 0); err != nil {
+		return 0, err
+	}
+
+	// The startup region, the padding after it and each module, in the order they are in the file. The
+	// regions of the original layout that had no NUL are too short for one
+	type section struct {
+		offset int
+		data   []byte
+	}
+
+	sections := []section{}
+	if len(b.Startup) > 0 {
+		sections = append(sections, section{0, append(append([]byte{}, b.Startup...), 0)[:startupRegion]})
+	}
+
+	sections = append(sections, section{startupRegion, layout.AfterStartup})
+	for id, entry := range entries {
+		if entry.Length > 0 {
+			sections = append(sections, section{entry.Offset, append(append([]byte{}, b.Modules[id]...), 0)[:entry.Length]})
+		}
+	}
+
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].offset < sections[j].offset
+	})
+
+	end := moduleStart
+	for _, section := range sections {
+		if gap := moduleStart + section.offset - end; gap > 0 {
+			if _, err := w.WriteAt(make([]byte, gap), int64(end)); err != nil {
+				return 0, err
+			}
+		}
+
+		if _, err := w.WriteAt(section.data, int64(moduleStart+section.offset)); err != nil {
+			return 0, err
+		}
+
+		end = max(end, moduleStart+section.offset+len(section.data))
+	}
+
+	if gap := length - len(layout.Trailing) - end; gap > 0 {
+		if _, err := w.WriteAt(make([]byte, gap), int64(end)); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := w.WriteAt(layout.Trailing, int64(length-len(layout.Trailing))); err != nil {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+// A bundle packed in memory
+type buffer struct {
+	data []byte
+}
+
+func (b *buffer) WriteAt(data []byte, offset int64) (int, error) {
+	if end := int(offset) + len(data); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+
+	return copy(b.data[offset:], data), nil
+}
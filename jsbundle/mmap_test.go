@@ -0,0 +1,102 @@
+//go:build linux || darwin || freebsd
+
+package jsbundle
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// The size of the bundle the benchmarks read, like a large app
+const BENCHMARK_MODULES = 20000
+const BENCHMARK_MODULE_SIZE = 1500
+
+// Write a bundle of BENCHMARK_MODULES modules to a temporary file
+func writeBenchmarkBundle(b *testing.B) string {
+	bundle := &Bundle{Startup: []byte("__r(0);"), Modules: make([][]byte, BENCHMARK_MODULES)}
+	for id := range bundle.Modules {
+		body := fmt.Sprintf("__d(function(g,r,i,a,m,e,d){m.exports=%q},%v,[])", strings.Repeat("x", BENCHMARK_MODULE_SIZE), id)
+		bundle.Modules[id] = []byte(body)
+	}
+
+	path := filepath.Join(b.TempDir(), "main.jsbundle")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer file.Close()
+
+	if err := bundle.Pack(file); err != nil {
+		b.Fatal(err)
+	}
+
+	return path
+}
+
+// Hash every module like verify-hashes does, so every page of the bundle is read
+func hashModules(bundle *Bundle) {
+	for _, module := range bundle.Modules {
+		sha256.Sum256(module)
+	}
+}
+
+// BenchmarkUnpack compares reading a bundle module by module with mapping it, the bytes allocated
+// per run are the memory each of them holds
+func BenchmarkUnpack(b *testing.B) {
+	path := writeBenchmarkBundle(b)
+
+	b.Run("read", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for range b.N {
+			file, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			bundle, err := Unpack(file)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			hashModules(bundle)
+			file.Close()
+		}
+	})
+
+	b.Run("mmap", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for range b.N {
+			file, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			info, err := file.Stat()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			bundle, err := UnpackBytes(data)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			hashModules(bundle)
+			syscall.Munmap(data)
+			file.Close()
+		}
+	})
+}
@@ -0,0 +1,557 @@
+package jsbundle
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ModuleRegex matches a whole module defined with __d, the groups are the factory arguments, the
+// factory body, the module id and the dependency array. The arguments can have any name since
+// minifiers rename them. The wrapper has to start the module and the footer has to end it, so
+// look-alike text in the body like an embedded asset is never taken for the wrapper
+var ModuleRegex = regexp.MustCompile(`(?s)^\s*__d\(function\s*\(\s*([\w$]+(?:\s*,\s*[\w$]+)*)\s*\)\s*\{(.*)\},\s*(\d+)\s*,\s*\[([\d,\s]*)\]\s*(?:,\s*"[^"]*"\s*)?\)\s*;?\s*$`)
+
+// The factory arguments are global, require, importDefault, importAll, module, exports and the
+// dependency map, the fields are their index
+const (
+	RequireArgument       = 1
+	ImportDefaultArgument = 2
+	ImportAllArgument     = 3
+	ModuleArgument        = 4
+	ExportsArgument       = 5
+	DependencyMapArgument = 6
+)
+
+// FactoryArguments gets the argument names of a module's factory, nil when it isn't wrapped in __d
+func FactoryArguments(module []byte) []string {
+	location := ModuleRegex.FindSubmatchIndex(module)
+	if location == nil {
+		return nil
+	}
+
+	arguments := strings.Split(string(module[location[2]:location[3]]), ",")
+	for index := range arguments {
+		arguments[index] = strings.TrimSpace(arguments[index])
+	}
+
+	return arguments
+}
+
+// PatchInfo is a patch file, the same JSON the CLI reads from the patches folder. Sidecar lines
+// aren't read by the library, patches have to carry their replacement
+type PatchInfo struct {
+	Name    string
+	Patches []Patch  `json:"patches"`
+	Modules *Imports `json:"modules"`
+	Target  string   `json:"target"`
+	Vars    []Var    `json:"vars"`
+}
+
+// Patch is one find and replacement. Modules and PathMatch limit the modules it applies to, Count
+// the replacements made in each module and ExpectedCount the replacements made across the bundle,
+// which are all made or none. AllModules only tells the CLI not to warn about several matches
+type Patch struct {
+	Find    *string `json:"find"`
+	Rfind   *string `json:"rfind"`
+	Replace *string `json:"replace"`
+	Append  *string `json:"append"`
+
+	Requires   *string `json:"requires"`
+	ExcludesIf *string `json:"excludesIf"`
+
+	PathMatch     *string  `json:"pathMatch"`
+	Modules       []string `json:"modules"`
+	AllModules    bool     `json:"allModules"`
+	Count         *int     `json:"count"`
+	ExpectedCount *int     `json:"expectedCount"`
+	CheckApplied  bool     `json:"checkApplied"`
+
+	// The {{Name}} values of this patch, on top of the ones of the patch file
+	Vars []Var `json:"vars"`
+}
+
+// Var is a {{Name}} substituted into the fields of the patches
+type Var struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CompiledPatch is a patch with its regexes compiled and Replace set to the replacement, appends
+// included
+type CompiledPatch struct {
+	Patch
+	Regex     *regexp.Regexp `json:"-"`
+	PathRegex *regexp.Regexp `json:"-"`
+}
+
+// Change is where a replacement ended up in the patched module, its length and how much it changed
+// the module length
+type Change struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+	Delta  int `json:"delta"`
+}
+
+// Imports are the modules required at the start of every module a patch file changes, as cmod1, cmod2...
+// Find adds the first module containing each string
+type Imports struct {
+	ToImport []string  `json:"toImport"`
+	Find     *[]string `json:"find"`
+}
+
+// InjectImport appends importID to the module's dependency array and requires it at the start of the
+// factory as name, with the factory's own names for require and the dependency map. Factories
+// without a dependency map argument can't import anything
+func InjectImport(module []byte, importID string, name string) ([]byte, bool) {
+	location := ModuleRegex.FindSubmatchIndex(module)
+	arguments := FactoryArguments(module)
+	if location == nil || len(arguments) <= DependencyMapArgument {
+		return module, false
+	}
+
+	bodyStart := location[4]
+	depsStart, depsEnd := location[8], location[9]
+
+	// The new dependency goes at the end of the array, so its index is the current length.
+	// A trailing comma is dropped so the array doesn't get an empty element
+	deps := strings.TrimRight(string(module[depsStart:depsEnd]), " \t\r\n")
+	deps = strings.TrimSuffix(deps, ",")
+	index := 0
+	if deps != "" {
+		index = len(strings.Split(deps, ","))
+		deps += ","
+	}
+
+	patched := []byte{}
+	patched = append(patched, module[:bodyStart]...)
+	patched = append(patched, fmt.Sprintf("var %v=%v(%v[%v]);", name, arguments[RequireArgument], arguments[DependencyMapArgument], index)...)
+	patched = append(patched, module[bodyStart:depsStart]...)
+	patched = append(patched, deps+importID...)
+	patched = append(patched, module[depsEnd:]...)
+
+	return patched, true
+}
+
+// ErrNoDependencyMap is returned for the modules whose factory has no dependency map argument
+var ErrNoDependencyMap = errors.New("the module doesn't have a __d wrapper with a dependency map argument")
+
+// InjectImports requires every import at the start of the module as cmod1, cmod2... An import the
+// module already requires under its name, from an earlier patch, isn't injected twice and skipped
+// counts it. A name requiring another module is an error since the patch would get the wrong module
+func InjectImports(module []byte, imports []string) (patched []byte, skipped int, err error) {
+	for index, importID := range imports {
+		name := fmt.Sprintf("cmod%v", index+1)
+		if strings.Contains(string(module), fmt.Sprintf("var %v=", name)) {
+			existing, ok := importedModule(module, name)
+			if !ok || existing != importID {
+				return module, skipped, fmt.Errorf("%v already requires %v, it can't also be module %v", name, describeImport(existing, ok), importID)
+			}
+
+			skipped++
+			continue
+		}
+
+		var ok bool
+		if module, ok = InjectImport(module, importID, name); !ok {
+			return module, skipped, ErrNoDependencyMap
+		}
+	}
+
+	return module, skipped, nil
+}
+
+// Get the module an injected var name requires, from the dependency array entry it indexes
+func importedModule(module []byte, name string) (string, bool) {
+	location := ModuleRegex.FindSubmatchIndex(module)
+	arguments := FactoryArguments(module)
+	if location == nil || len(arguments) <= DependencyMapArgument {
+		return "", false
+	}
+
+	pattern := fmt.Sprintf(`var %v=%v\(%v\[(\d+)\]\);`, name, regexp.QuoteMeta(arguments[RequireArgument]), regexp.QuoteMeta(arguments[DependencyMapArgument]))
+	match := regexp.MustCompile(pattern).FindSubmatch(module[location[4]:location[5]])
+	if match == nil {
+		return "", false
+	}
+
+	index, _ := strconv.Atoi(string(match[1]))
+	deps := strings.Split(string(module[location[8]:location[9]]), ",")
+	if index >= len(deps) {
+		return "", false
+	}
+
+	return strings.TrimSpace(deps[index]), true
+}
+
+func describeImport(id string, ok bool) string {
+	if !ok {
+		return "something that isn't a dependency of the module"
+	}
+
+	return "module " + id
+}
+
+var varReferenceRegex = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+// A $ reference of a regex replacement, $$ is a literal $
+var groupReferenceRegex = regexp.MustCompile(`\$(?:\$|\{([^}]*)\}|(\w+))`)
+
+// SubstituteVars replaces the {{Name}} references in the find, rfind, replace, append, requires
+// and excludesIf of a patch with the vars of its patch file and its own, which win. An unknown
+// name is an error, patches without vars are left as they are
+func SubstituteVars(fileVars []Var, patch Patch) (Patch, error) {
+	if len(fileVars) == 0 && len(patch.Vars) == 0 {
+		return patch, nil
+	}
+
+	vars := map[string]string{}
+	for _, variable := range append(append([]Var{}, fileVars...), patch.Vars...) {
+		vars[variable.Name] = variable.Value
+	}
+
+	for _, field := range []**string{&patch.Find, &patch.Rfind, &patch.Replace, &patch.Append, &patch.Requires, &patch.ExcludesIf} {
+		if *field == nil {
+			continue
+		}
+
+		failed := error(nil)
+		value := varReferenceRegex.ReplaceAllStringFunc(**field, func(reference string) string {
+			value, ok := vars[varReferenceRegex.FindStringSubmatch(reference)[1]]
+			if !ok && failed == nil {
+				failed = fmt.Errorf("the variable %v isn't defined", reference)
+			}
+
+			return value
+		})
+
+		if failed != nil {
+			return patch, failed
+		}
+
+		*field = &value
+	}
+
+	return patch, nil
+}
+
+// CompilePatch compiles the regexes of a patch and resolves its append to a replacement. The
+// appended text stays literal in regex patches, its $ don't refer to groups
+func CompilePatch(patch Patch) (CompiledPatch, error) {
+	compiled := CompiledPatch{Patch: patch}
+
+	switch {
+	case patch.Rfind != nil:
+		regex, err := regexp.Compile(*patch.Rfind)
+		if err != nil {
+			return compiled, fmt.Errorf("its rfind is invalid: %w", err)
+		}
+
+		compiled.Regex = regex
+	case patch.Find == nil || *patch.Find == "":
+		return compiled, errors.New("it has nothing to find")
+	}
+
+	if patch.PathMatch != nil {
+		regex, err := regexp.Compile(*patch.PathMatch)
+		if err != nil {
+			return compiled, fmt.Errorf("its pathMatch is invalid: %w", err)
+		}
+
+		compiled.PathRegex = regex
+	}
+
+	for _, moduleID := range patch.Modules {
+		if _, err := strconv.Atoi(moduleID); err != nil && moduleID != "startup" {
+			return compiled, fmt.Errorf("module %v isn't a numeric module id", moduleID)
+		}
+	}
+
+	if patch.Count != nil && *patch.Count == 0 {
+		return compiled, errors.New("its count is 0, it would replace nothing")
+	}
+
+	if patch.Count != nil && *patch.Count < -1 {
+		return compiled, errors.New("its count is negative, -1 replaces every occurrence")
+	}
+
+	var replace string
+	switch {
+	case patch.Replace != nil:
+		replace = *patch.Replace
+	case patch.Append != nil && compiled.Regex != nil:
+		replace = "${0}" + strings.ReplaceAll(*patch.Append, "$", "$$")
+	case patch.Append != nil:
+		replace = *patch.Find + *patch.Append
+	default:
+		return compiled, errors.New("it has no replacement")
+	}
+
+	compiled.Replace = &replace
+
+	if compiled.Regex != nil {
+		if err := CheckGroupReferences(compiled.Regex, replace); err != nil {
+			return compiled, fmt.Errorf("its replacement is invalid: %w", err)
+		}
+	}
+
+	return compiled, nil
+}
+
+// CheckGroupReferences checks that the $1 and ${name} references of a regex replacement are groups
+// of the regex, Go replaces the unknown ones with nothing
+func CheckGroupReferences(find *regexp.Regexp, replace string) error {
+	for _, match := range groupReferenceRegex.FindAllStringSubmatch(replace, -1) {
+		if match[0] == "$$" {
+			continue
+		}
+
+		name := match[1] + match[2]
+		if number, err := strconv.Atoi(name); err == nil {
+			if number > find.NumSubexp() {
+				return fmt.Errorf("%v refers to group %v but the regex only has %v", match[0], number, find.NumSubexp())
+			}
+
+			continue
+		}
+
+		if name == "" || !slices.Contains(find.SubexpNames(), name) {
+			return fmt.Errorf("%v isn't a group of the regex, write ${1}x instead of $1x and $$ for a literal $", match[0])
+		}
+	}
+
+	return nil
+}
+
+// AppliesTo reports whether the module id and source path are in the modules and pathMatch of the
+// patch. A module without a known path is outside of every pathMatch
+func (patch CompiledPatch) AppliesTo(moduleID string, path string, hasPath bool) bool {
+	if len(patch.Modules) > 0 && !slices.Contains(patch.Modules, moduleID) {
+		return false
+	}
+
+	return patch.PathRegex == nil || hasPath && patch.PathRegex.MatchString(path)
+}
+
+// Finds reports whether the patch finds its text or regex in code
+func (patch CompiledPatch) Finds(code []byte) bool {
+	if patch.Regex != nil {
+		return patch.Regex.Match(code)
+	}
+
+	return strings.Contains(string(code), *patch.Find)
+}
+
+// Guards reports whether code has the requires text of the patch and not its excludesIf text
+func (patch CompiledPatch) Guards(code []byte) bool {
+	if patch.Requires != nil && !strings.Contains(string(code), *patch.Requires) {
+		return false
+	}
+
+	return patch.ExcludesIf == nil || !strings.Contains(string(code), *patch.ExcludesIf)
+}
+
+// Applied reports whether the replacement of the patch is already in code. Regex replacements
+// using groups can't be known in advance so they're never considered applied
+func (patch CompiledPatch) Applied(code []byte) bool {
+	replace := *patch.Replace
+	if patch.Regex != nil {
+		// Only a replacement without group references is the text it writes
+		if strings.Contains(strings.ReplaceAll(replace, "$$", ""), "$") {
+			return false
+		}
+
+		replace = strings.ReplaceAll(replace, "$$", "$")
+	}
+
+	return replace != "" && strings.Contains(string(code), replace)
+}
+
+// ReplaceIn replaces the matches of the patch in code one at a time, up to its count, and returns
+// the patched code with the change each replacement made
+func (patch CompiledPatch) ReplaceIn(code []byte) ([]byte, []Change) {
+	limit := -1
+	if patch.Count != nil {
+		limit = *patch.Count
+	}
+
+	matches := [][]int{}
+	if patch.Regex != nil {
+		matches = patch.Regex.FindAllSubmatchIndex(code, limit)
+	} else if *patch.Find != "" {
+		for start := 0; limit < 0 || len(matches) < limit; {
+			index := strings.Index(string(code[start:]), *patch.Find)
+			if index == -1 {
+				break
+			}
+
+			matches = append(matches, []int{start + index, start + index + len(*patch.Find)})
+			start += index + len(*patch.Find)
+		}
+	}
+
+	patched := []byte{}
+	changes := []Change{}
+	last := 0
+
+	for _, match := range matches {
+		patched = append(patched, code[last:match[0]]...)
+		offset := len(patched)
+
+		if patch.Regex != nil {
+			patched = patch.Regex.Expand(patched, []byte(*patch.Replace), code, match)
+		} else {
+			patched = append(patched, *patch.Replace...)
+		}
+
+		length := len(patched) - offset
+		changes = append(changes, Change{Offset: offset, Length: length, Delta: length - (match[1] - match[0])})
+		last = match[1]
+	}
+
+	return append(patched, code[last:]...), changes
+}
+
+// CheckTarget checks the target of a patch file, only the startup code can be targeted and it can't
+// import modules
+func CheckTarget(target string, imports bool) error {
+	if target != "" && target != "startup" {
+		return fmt.Errorf("it has an unknown target %v", target)
+	}
+
+	if target == "startup" && imports {
+		return errors.New("it targets the startup code, which can't import modules")
+	}
+
+	return nil
+}
+
+// ApplyPatches applies the patch files in order with ApplyPatchFile, resolving their vars and imports
+// first. Patches with a pathMatch only apply to the modules that have a path in Paths. The Transforms
+// of the options run once, with the last patch file, and are in its report
+func (b *Bundle) ApplyPatches(patches []PatchInfo, options PatchOptions) ([]Report, error) {
+	reports := []Report{}
+
+	for index, info := range patches {
+		if err := CheckTarget(info.Target, info.Modules != nil); err != nil {
+			return reports, fmt.Errorf("patch %v can't be loaded, %w", info.Name, err)
+		}
+
+		file := PatchFile{Name: info.Name, Target: info.Target}
+		for index, patch := range info.Patches {
+			patch, err := SubstituteVars(info.Vars, patch)
+			if err == nil {
+				var compiled CompiledPatch
+				compiled, err = CompilePatch(patch)
+				file.Patches = append(file.Patches, compiled)
+			}
+
+			if err != nil {
+				return reports, fmt.Errorf("patch %v#%v can't be loaded, %w", info.Name, index, err)
+			}
+		}
+
+		var err error
+		if file.Imports, _, err = ResolveImports(b, b.IDs(), info.Modules); err != nil {
+			return reports, fmt.Errorf("patch %v: %w", info.Name, err)
+		}
+
+		fileOptions := options
+		if index < len(patches)-1 {
+			fileOptions.Transforms = nil
+		}
+
+		report, err := ApplyPatchFile(b, b.IDs(), file, fileOptions)
+		reports = append(reports, report)
+		if err != nil {
+			return reports, err
+		}
+	}
+
+	return reports, nil
+}
+
+// IDs gets the "startup" id of the startup code and the ids of the modules that aren't empty, in order
+func (b *Bundle) IDs() []string {
+	ids := []string{"startup"}
+	for id, module := range b.Modules {
+		if module != nil {
+			ids = append(ids, strconv.Itoa(id))
+		}
+	}
+
+	return ids
+}
+
+// Module gets a module by id, the startup code included
+func (b *Bundle) Module(id string) []byte {
+	if id == "startup" {
+		return b.Startup
+	}
+
+	index, _ := strconv.Atoi(id)
+	return b.Modules[index]
+}
+
+// SetModule replaces a module by id, the startup code included
+func (b *Bundle) SetModule(id string, code []byte) {
+	if id == "startup" {
+		b.Startup = code
+		return
+	}
+
+	index, _ := strconv.Atoi(id)
+	b.Modules[index] = code
+}
+
+// Path gets the source path of a module from Paths
+func (b *Bundle) Path(id string) (string, bool) {
+	index, err := strconv.Atoi(id)
+	if err != nil {
+		return "", false
+	}
+
+	path, ok := b.Paths[index]
+	return path, ok
+}
+
+// ResolveImports gets the ids of the modules a patch file imports, adding the first module of ids
+// containing each find. The finds that matched no module are returned with them
+func ResolveImports(m Modules, ids []string, imports *Imports) ([]string, []string, error) {
+	if imports == nil {
+		return nil, nil, nil
+	}
+
+	resolved := append([]string{}, imports.ToImport...)
+	unmatched := []string{}
+
+	if imports.Find != nil {
+		for _, find := range *imports.Find {
+			found := false
+
+			for _, id := range ids {
+				// The startup code can't be required by a module
+				if id != "startup" && strings.Contains(string(m.Module(id)), find) {
+					resolved = append(resolved, id)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				unmatched = append(unmatched, find)
+			}
+		}
+	}
+
+	for _, id := range resolved {
+		if _, err := strconv.Atoi(id); err != nil {
+			return nil, nil, fmt.Errorf("module %v is not a numeric module id", id)
+		}
+	}
+
+	return resolved, unmatched, nil
+}
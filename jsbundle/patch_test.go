@@ -0,0 +1,206 @@
+package jsbundle
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInjectImportsAcrossPatchFiles(t *testing.T) {
+	module := "__d(function(e,t,n,r,o,i,a){o.exports=1},7,[1,2])"
+	first := PatchInfo{Name: "a", Patches: []Patch{{Find: stringPointer("o.exports=1"), Replace: stringPointer("o.exports=cmod1")}}, Modules: &Imports{ToImport: []string{"3"}}}
+
+	tests := []struct {
+		name   string
+		second PatchInfo
+		want   string
+		failed bool
+	}{
+		{
+			name:   "same import",
+			second: PatchInfo{Name: "b", Patches: []Patch{{Find: stringPointer("=cmod1"), Replace: stringPointer("=cmod1()")}}, Modules: &Imports{ToImport: []string{"3"}}},
+			want:   "__d(function(e,t,n,r,o,i,a){var cmod1=t(a[2]);o.exports=cmod1()},7,[1,2,3])",
+		},
+		{
+			name:   "one more import",
+			second: PatchInfo{Name: "b", Patches: []Patch{{Find: stringPointer("=cmod1"), Replace: stringPointer("=cmod2(cmod1)")}}, Modules: &Imports{ToImport: []string{"3", "4"}}},
+			want:   "__d(function(e,t,n,r,o,i,a){var cmod2=t(a[3]);var cmod1=t(a[2]);o.exports=cmod2(cmod1)},7,[1,2,3,4])",
+		},
+		{
+			name:   "another module under the same name",
+			second: PatchInfo{Name: "b", Patches: []Patch{{Find: stringPointer("=cmod1"), Replace: stringPointer("=cmod1()")}}, Modules: &Imports{ToImport: []string{"4"}}},
+			want:   "__d(function(e,t,n,r,o,i,a){var cmod1=t(a[2]);o.exports=cmod1},7,[1,2,3])",
+			failed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle := &Bundle{Modules: make([][]byte, 8)}
+			bundle.Modules[7] = []byte(module)
+
+			_, err := bundle.ApplyPatches([]PatchInfo{first, test.second}, PatchOptions{})
+			if (err != nil) != test.failed {
+				t.Fatalf("the error is %v, want one: %v", err, test.failed)
+			}
+
+			if string(bundle.Modules[7]) != test.want {
+				t.Errorf("module 7 is %q, want %q", bundle.Modules[7], test.want)
+			}
+		})
+	}
+}
+
+func TestReplaceInGroupReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		rfind   string
+		replace string
+		want    string
+		invalid bool
+	}{
+		{"named group", `exports=(?P<value>\d+)`, "exports=${value}0", "m.exports=10;m.exports=20", false},
+		{"numbered group", `exports=(\d+)`, "exports=$1+1", "m.exports=1+1;m.exports=2+1", false},
+		{"braced group before a letter", `exports=(\d+)`, "exports=${1}x", "m.exports=1x;m.exports=2x", false},
+		{"literal dollar", `exports=(\d+)`, "exports=$$$1", "m.exports=$1;m.exports=$2", false},
+		{"unknown named group", `exports=(?P<value>\d+)`, "exports=${other}", "", true},
+		{"group past the last one", `exports=(\d+)`, "exports=$2", "", true},
+		{"number run into a letter", `exports=(\d+)`, "exports=$1x", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch, err := CompilePatch(Patch{Rfind: stringPointer(test.rfind), Replace: stringPointer(test.replace)})
+			if (err != nil) != test.invalid {
+				t.Fatalf("the error is %v, want one: %v", err, test.invalid)
+			}
+
+			if test.invalid {
+				if err := CheckGroupReferences(patch.Regex, test.replace); err == nil {
+					t.Errorf("CheckGroupReferences accepted %q", test.replace)
+				}
+
+				return
+			}
+
+			patched, changes := patch.ReplaceIn([]byte("m.exports=1;m.exports=2"))
+			if string(patched) != test.want {
+				t.Errorf("the patched code is %q, want %q", patched, test.want)
+			}
+
+			if len(changes) != 2 {
+				t.Errorf("%v replacements were made, want 2", len(changes))
+			}
+		})
+	}
+}
+
+func TestInjectImportDependencyArrays(t *testing.T) {
+	tests := []struct {
+		name string
+		deps string
+		want string
+	}{
+		{"empty", "[]", "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[0]);m.exports=1},7,[42])"},
+		{"empty with whitespace", "[ ]", "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[0]);m.exports=1},7,[42])"},
+		{"single", "[1]", "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[1]);m.exports=1},7,[1,42])"},
+		{"multiple", "[1,2,3]", "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[3]);m.exports=1},7,[1,2,3,42])"},
+		{"trailing comma", "[1,2,]", "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[2]);m.exports=1},7,[1,2,42])"},
+		{"trailing comma and newline", "[1,\n]", "__d(function(g,r,i,a,m,e,d){var cmod1=r(d[1]);m.exports=1},7,[1,42])"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patched, ok := InjectImport([]byte("__d(function(g,r,i,a,m,e,d){m.exports=1},7,"+test.deps+")"), "42", "cmod1")
+			if !ok {
+				t.Fatal("the import wasn't injected")
+			}
+
+			if string(patched) != test.want {
+				t.Errorf("the module is %q, want %q", patched, test.want)
+			}
+		})
+	}
+}
+
+func TestInjectImportsResolveAtRuntime(t *testing.T) {
+	bundle := &Bundle{Startup: []byte("__r(305);"), Modules: make([][]byte, 400)}
+	bundle.Modules[305] = []byte("__d(function(global,req,importDefault,importAll,module,exports,deps){module.exports=req(deps[0])+req(deps[1])},305,[77,12])")
+	bundle.Modules[77] = []byte("__d(function(g,r,i,a,m,e,d){m.exports=77},77,[])")
+	bundle.Modules[12] = []byte("__d(function(g,r,i,a,m,e,d){m.exports=12},12,[])")
+	bundle.Modules[391] = []byte("__d(function(g,r,i,a,m,e,d){m.exports=391},391,[])")
+
+	info := PatchInfo{
+		Name:    "test",
+		Patches: []Patch{{Find: stringPointer("module.exports="), Replace: stringPointer("module.exports=cmod1+cmod2+")}},
+		Modules: &Imports{ToImport: []string{"391", "12"}},
+	}
+
+	if _, err := bundle.ApplyPatches([]PatchInfo{info}, PatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resolve every require of the factory the way the runtime does, through the dependency array
+	module := bundle.Modules[305]
+	location := ModuleRegex.FindSubmatchIndex(module)
+	if location == nil {
+		t.Fatalf("the patched module isn't a __d call anymore: %q", module)
+	}
+
+	if id := string(module[location[6]:location[7]]); id != "305" {
+		t.Errorf("the module id became %v", id)
+	}
+
+	deps := strings.Split(string(module[location[8]:location[9]]), ",")
+	requires := regexp.MustCompile(`req\(deps\[(\d+)\]\)`).FindAllSubmatch(module[location[4]:location[5]], -1)
+	resolved := []string{}
+
+	for _, require := range requires {
+		index, _ := strconv.Atoi(string(require[1]))
+		if index >= len(deps) {
+			t.Fatalf("deps[%v] is past the dependency array %v", index, deps)
+		}
+
+		dep, _ := strconv.Atoi(deps[index])
+		if !strings.Contains(string(bundle.Modules[dep]), fmt.Sprintf("},%v,[", dep)) {
+			t.Errorf("deps[%v] is %v, which isn't a module of the bundle", index, dep)
+		}
+
+		resolved = append(resolved, deps[index])
+	}
+
+	// The injected requires come first, then the ones the factory already had
+	if got := strings.Join(resolved, ","); got != "12,391,77,12" {
+		t.Errorf("the requires resolve to %v, want 12,391,77,12", got)
+	}
+
+	for index, name := range []string{"cmod1", "cmod2"} {
+		if id, ok := importedModule(module, name); !ok || id != info.Modules.ToImport[index] {
+			t.Errorf("%v requires %v, want %v", name, id, info.Modules.ToImport[index])
+		}
+	}
+}
+
+func TestCompilePatchCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		invalid bool
+	}{
+		{"every occurrence", -1, false},
+		{"first occurrence", 1, false},
+		{"nothing", 0, true},
+		{"negative", -2, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CompilePatch(Patch{Find: stringPointer("m.exports="), Replace: stringPointer(""), Count: intPointer(test.count)})
+			if (err != nil) != test.invalid {
+				t.Errorf("the error is %v, want one: %v", err, test.invalid)
+			}
+		})
+	}
+}
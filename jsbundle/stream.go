@@ -0,0 +1,87 @@
+package jsbundle
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ReadModule reads the module of an entry without its NUL terminator. The startup code is the entry
+// at offset 0 with the startup length of the header
+func ReadModule(r io.ReaderAt, header Header, entry Entry) ([]byte, error) {
+	offset := ModuleStart(header.EntryCount) + entry.Offset
+	if err := checkRange(r, offset, entry.Length); err != nil {
+		return nil, fmt.Errorf("could not read %v bytes at offset %v: %w", entry.Length, offset, err)
+	}
+
+	data := make([]byte, entry.Length)
+	if err := readFull(r, data, offset); err != nil {
+		return nil, fmt.Errorf("could not read %v bytes at offset %v: %w", entry.Length, offset, err)
+	}
+
+	return trimTerminator(data), nil
+}
+
+// Drop the NUL terminator, a module without one keeps its last byte
+func trimTerminator(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == 0 {
+		return data[:len(data)-1]
+	}
+
+	return data
+}
+
+// FileWriter is where UnpackStreamTo writes the files, like a folder or an archive
+type FileWriter interface {
+	WriteFile(name string, data []byte) error
+}
+
+// UnpackStream writes the startup code of a bundle to startup.js in dir and every module to
+// <id>.js, reading and writing one at a time so the bundle is never held in memory. Empty
+// entries are written as empty files to keep every id
+func UnpackStream(r io.ReaderAt, dir string) error {
+	return UnpackStreamTo(r, folder(dir))
+}
+
+// UnpackStreamTo writes the files of UnpackStream to w instead of a folder
+func UnpackStreamTo(r io.ReaderAt, w FileWriter) error {
+	header, entries, err := ReadEntryTable(r)
+	if err != nil {
+		return err
+	}
+
+	startup, err := ReadModule(r, header, Entry{Length: header.StartupLength})
+	if err != nil {
+		return fmt.Errorf("the startup code: %w", err)
+	}
+
+	if err := w.WriteFile("startup.js", startup); err != nil {
+		return err
+	}
+
+	for id, entry := range entries {
+		module, err := ReadModule(r, header, entry)
+		if err != nil {
+			return fmt.Errorf("module %v: %w", id, err)
+		}
+
+		if err := w.WriteFile(strconv.Itoa(id)+".js", module); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A folder created on the first write
+type folder string
+
+func (dir folder) WriteFile(name string, data []byte) error {
+	if err := os.MkdirAll(string(dir), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(string(dir), name), data, 0666)
+}
@@ -0,0 +1,69 @@
+package jsbundle
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// TransformFunc rewrites the code of a module, returning it unchanged if it doesn't apply. The id of
+// the startup code is "startup"
+type TransformFunc func(id string, body []byte) ([]byte, error)
+
+// Transform is a TransformFunc and the name it's reported with
+type Transform struct {
+	Name string
+	Fn   TransformFunc
+}
+
+// TransformReport is what one transform did, Changed has the modules it changed in the order of the ids
+type TransformReport struct {
+	Name    string
+	Changed []string
+}
+
+var sourceMapURLRegex = regexp.MustCompile(`(?m)^//# sourceMappingURL=.*$\n?`)
+
+// Console calls that aren't a property of something else, like logger.console.log(
+var consoleCallRegex = regexp.MustCompile(`(^|[^\w$.])console\.(log|info|debug)\(`)
+
+var builtinTransforms = map[string]TransformFunc{
+	"strip-sourcemap-url": func(id string, body []byte) ([]byte, error) {
+		return sourceMapURLRegex.ReplaceAll(body, nil), nil
+	},
+	"disable-console": func(id string, body []byte) ([]byte, error) {
+		return consoleCallRegex.ReplaceAll(body, []byte("${1}void(")), nil
+	},
+}
+
+// BuiltinTransform gets one of the transforms the CLI can select by name, strip-sourcemap-url or
+// disable-console
+func BuiltinTransform(name string) (TransformFunc, bool) {
+	fn, ok := builtinTransforms[name]
+	return fn, ok
+}
+
+// ApplyTransforms runs the transforms in order on the modules of ids, the startup code included
+func ApplyTransforms(m Modules, ids []string, transforms []Transform) ([]TransformReport, error) {
+	reports := make([]TransformReport, len(transforms))
+
+	for index, transform := range transforms {
+		reports[index].Name = transform.Name
+
+		for _, id := range ids {
+			code := m.Module(id)
+			body, err := transform.Fn(id, code)
+			if err != nil {
+				return reports, fmt.Errorf("transform %v failed on module %v: %w", transform.Name, id, err)
+			}
+
+			if !bytes.Equal(body, code) {
+				reports[index].Changed = append(reports[index].Changed, id)
+			}
+
+			m.SetModule(id, body)
+		}
+	}
+
+	return reports, nil
+}
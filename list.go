@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+type PatchFileReport struct {
+	File          string   `json:"file"`
+	Patches       int      `json:"patches"`
+	Target        string   `json:"target"`
+	PathScoped    int      `json:"pathScoped"`
+	Sidecar       string   `json:"sidecar,omitempty"`
+	SidecarExists bool     `json:"sidecarExists"`
+	Issues        []string `json:"issues"`
+}
+
+// Find the problems of a patch file without stopping at the first one like loading it does
+func inspectPatchFile(name string, content []byte) PatchFileReport {
+	report := PatchFileReport{File: name, Target: "modules", Issues: []string{}}
+
+	var info PatchInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		report.Issues = append(report.Issues, fmt.Sprintf("the file isn't valid JSON: %v", err))
+		return report
+	}
+
+	report.Patches = len(info.Patches)
+
+	switch info.Target {
+	case "":
+	case "startup":
+		report.Target = "startup"
+		if info.Modules != nil {
+			report.Issues = append(report.Issues, "it targets the startup code, which can't import modules")
+		}
+	default:
+		report.Target = info.Target
+		report.Issues = append(report.Issues, fmt.Sprintf("the target %v is unknown", info.Target))
+	}
+
+	if len(info.Patches) == 0 && len(info.NewModules) == 0 && len(info.DepOps) == 0 && len(info.RemoveModules) == 0 && len(info.RemoveModulesFind) == 0 {
+		report.Issues = append(report.Issues, "it has no patches, new modules, dependency edits or removed modules")
+	}
+
+	for _, moduleID := range info.RemoveModules {
+		if id, err := strconv.Atoi(moduleID); err != nil || id < 0 {
+			report.Issues = append(report.Issues, fmt.Sprintf("it removes %v, which isn't a module id", moduleID))
+		}
+	}
+
+	ids := map[int]bool{}
+	for index, newModule := range info.NewModules {
+		switch {
+		case newModule.ID == nil:
+		case *newModule.ID < 0:
+			report.Issues = append(report.Issues, fmt.Sprintf("new module #%v has a negative id", index))
+		case ids[*newModule.ID]:
+			report.Issues = append(report.Issues, fmt.Sprintf("new module #%v uses id %v twice", index, *newModule.ID))
+		default:
+			ids[*newModule.ID] = true
+		}
+	}
+
+	for index, op := range info.DepOps {
+		if op.AddDep == nil && op.RemoveDep == nil {
+			report.Issues = append(report.Issues, fmt.Sprintf("dependency edit #%v neither adds nor removes a dependency", index))
+		}
+	}
+
+	sidecarPath := strings.TrimSuffix(name, filepath.Ext(name)) + ".js"
+	if info.Sidecar != nil {
+		sidecarPath = *info.Sidecar
+	}
+
+	var lines []string
+	for _, patch := range info.Patches {
+		if patch.FReplace != nil || patch.Fappend != nil {
+			report.Sidecar = sidecarPath
+
+			if _, err := os.Stat(filepath.Join(patchesDir, sidecarPath)); err == nil {
+				report.SidecarExists = true
+				lines = readSidecarLines(filepath.Join(patchesDir, sidecarPath), name)
+			} else {
+				report.Issues = append(report.Issues, fmt.Sprintf("the sidecar %v doesn't exist", sidecarPath))
+			}
+
+			break
+		}
+	}
+
+	for index, patch := range info.Patches {
+		issue := func(format string, args ...any) {
+			report.Issues = append(report.Issues, fmt.Sprintf("patch #%v ", index)+fmt.Sprintf(format, args...))
+		}
+
+		// The other checks see the patch with its variables substituted, like loading it does
+		if resolved, err := jsbundle.SubstituteVars(info.Vars, patch.Patch); err != nil {
+			issue("can't be loaded, %v", err)
+		} else {
+			patch.Patch = resolved
+		}
+
+		for _, moduleID := range patch.Modules {
+			if _, err := strconv.Atoi(moduleID); err != nil && moduleID != "startup" {
+				issue("targets %v, which isn't a module id", moduleID)
+			}
+		}
+
+		if patch.PathMatch != nil {
+			report.PathScoped++
+
+			if _, err := regexp.Compile(*patch.PathMatch); err != nil {
+				issue("has an invalid path regex: %v", err)
+			}
+		}
+
+		switch {
+		case patch.Find == nil && patch.Rfind == nil:
+			issue("has nothing to find")
+		case patch.Find != nil && patch.Rfind != nil:
+			issue("has both find and rfind")
+		case patch.Rfind != nil:
+			find, err := regexp.Compile(*patch.Rfind)
+			if err != nil {
+				issue("has an invalid regex: %v", err)
+			} else if patch.Replace != nil {
+				if err := jsbundle.CheckGroupReferences(find, *patch.Replace); err != nil {
+					issue("has an invalid replacement: %v", err)
+				}
+			}
+		}
+
+		if patch.Count != nil && *patch.Count == 0 {
+			issue("has a count of 0, it would replace nothing")
+		}
+
+		if patch.Count != nil && *patch.Count < -1 {
+			issue("has a negative count, -1 replaces every occurrence")
+		}
+
+		if patch.Replace == nil && patch.FReplace == nil && patch.Append == nil && patch.Fappend == nil {
+			issue("has no replacement")
+		}
+
+		for _, line := range []*int{patch.FReplace, patch.Fappend} {
+			if line == nil || !report.SidecarExists {
+				continue
+			}
+
+			if *line < 0 || *line >= len(lines) {
+				issue("uses line %v, which is out of range in %v (%v lines)", *line, sidecarPath, len(lines))
+			} else if lines[*line] == "" && !allowEmptyLine {
+				issue("uses line %v of %v, which is empty", *line, sidecarPath)
+			}
+		}
+	}
+
+	return report
+}
+
+// Print every patch file of the patches folder with its patch count, target, sidecar and problems,
+// exits with an error if any file has a problem
+func listPatches() {
+	files, err := os.ReadDir(patchesDir)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	reports := []PatchFileReport{}
+	patchCount := 0
+	issueCount := 0
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(patchesDir, file.Name()))
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		report := inspectPatchFile(file.Name(), content)
+		reports = append(reports, report)
+		patchCount += report.Patches
+		issueCount += len(report.Issues)
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		fmt.Println(string(output))
+	} else {
+		for _, report := range reports {
+			fmt.Printf("%v: %v patches, targets %v", report.File, report.Patches, report.Target)
+			if report.PathScoped > 0 {
+				fmt.Printf(" (%v path scoped)", report.PathScoped)
+			}
+
+			if report.Sidecar != "" {
+				if report.SidecarExists {
+					fmt.Printf(", sidecar %v", report.Sidecar)
+				} else {
+					fmt.Printf(", missing sidecar %v", report.Sidecar)
+				}
+			}
+
+			fmt.Println()
+
+			for _, issue := range report.Issues {
+				fmt.Printf("    %v\n", issue)
+			}
+		}
+
+		fmt.Printf("%v patch files, %v patches, %v issues.\n", len(reports), patchCount, issueCount)
+	}
+
+	if issueCount > 0 {
+		exitFailed()
+	}
+}
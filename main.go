@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"regexp"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
 )
 
 type entry struct {
@@ -16,88 +26,584 @@ type entry struct {
 	length int
 }
 
+type UnpackedFile struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
 type PatchInfo struct {
-	Name    string
-	Patches []PatchData `json:"patches"`
-	Modules *ModuleData `json:"modules"`
+	Name       string
+	Patches    []PatchData       `json:"patches"`
+	Modules    *jsbundle.Imports `json:"modules"`
+	NewModules []NewModuleData   `json:"newModules"`
+	Sidecar    *string           `json:"sidecar"`
+	DepOps     []DependencyOp    `json:"depOps"`
+	Target     string            `json:"target"`
+	Vars       []jsbundle.Var    `json:"vars"`
+
+	RemoveModules     []string `json:"removeModules"`
+	RemoveModulesFind []string `json:"removeModulesFind"`
 }
 
 type PatchData struct {
-	FindRegex *regexp.Regexp
-
-	Find  *string
-	Rfind *string
+	jsbundle.CompiledPatch
 
-	Replace  *string
+	// The sidecar lines replacing the match or appended after it when the patch has no replace or append
 	FReplace *int
+	Fappend  *int
+}
 
-	Append  *string
-	Fappend *int
-
-	vars *[]struct {
-		Name  string
-		Value string
-	}
+type DependencyOp struct {
+	Module    int  `json:"module"`
+	AddDep    *int `json:"addDep"`
+	RemoveDep *int `json:"removeDep"`
 }
 
-type ModuleData struct {
-	ToImport []string
-	Find     *[]string
+type NewModuleData struct {
+	// The id the module is inserted as, the next free one when nil
+	ID   *int
+	Body string
+	Deps []int
 }
 
 const UINT32_LENGTH = 4
+const VERSION = "1.1.0"
 
 var mode string
 var bundlePath string
 var outputFilename string
 var outputDir string
 var patchesDir string
+var sourceMapPath string
+var minLength int
+var top int
+var jsonOutput bool
+var profile bool
+var cpuProfilePath string
+var memProfilePath string
+var csvOutput bool
+var absoluteOffsets bool
+var allowEmptyLine bool
+var dryRun bool
+var naming string
+var dumpPatched bool
+var patchFolder bool
+var embedProvenance bool
+var retries int
+var esm bool
+var seedIDs int
+var stripPaths string
+var force bool
+var stripReachable bool
+var noStartup bool
+var extractMaps bool
+var stripMapComment bool
+var mapOutPath string
+var layout string
+var transformNames string
+var regexTimeout time.Duration
+var selectedModule string
+var renameFrom string
+var renameTo string
+var includeProps bool
+var compareBundlePath string
+var hashesPath string
+var recordPositionsPath string
+var minSize int
+var maxSize int
+var sizeFilter bool
+var align int
+var archiveFormat string
+var sourceDateEpoch int64
+var explain bool
+var normalizeLevel string
+var startupLen int
+var jobs int
+var countOnly bool
+var warningsAsErrors bool
+var quiet bool
+var renamePack bool
+var setEntry int
+var useMmap bool
+var checkBalance bool
+var bundleFormat string
+var strict bool
+var patchJobs int
+
+// Flags that apply in every mode
+var globalFlags = []string{"m", "cpuprofile", "memprofile", "retries", "Werror", "quiet"}
+
+// Flags that apply to each mode, -p and -d are required when listed
+var modeFlags = map[string][]string{
+	"unpack":        {"p", "o", "s", "json", "dry-run", "naming", "map-out", "layout", "esm", "extract-maps", "strip-map-comment", "min-size", "max-size", "archive-format", "source-date-epoch", "jobs", "mmap", "force"},
+	"pack":          {"n", "o", "embed-provenance", "no-startup", "align", "source-date-epoch", "startup-len", "dry-run", "json", "format", "force"},
+	"patch":         {"p", "n", "d", "o", "s", "profile", "allow-empty-line", "naming", "transform", "embed-provenance", "seed-ids", "no-startup", "regex-timeout", "record-positions", "align", "source-date-epoch", "explain", "jobs", "set-entry", "check-balance", "dry-run", "strict", "j", "mmap", "force"},
+	"strings":       {"p", "min-length", "top", "json", "mmap"},
+	"split":         {"p", "n", "force"},
+	"table":         {"p", "s", "csv", "absolute", "mmap"},
+	"check":         {"p", "mmap"},
+	"info":          {"p", "s", "min-size", "max-size", "top", "mmap", "json"},
+	"canon":         {"p", "n", "force"},
+	"strip":         {"p", "n", "s", "strip-paths", "strip-reachable", "min-size", "max-size", "force"},
+	"rename":        {"p", "n", "module", "from", "to", "include-props", "force"},
+	"verify-hashes": {"p", "s", "compare", "hashes", "normalize", "count-only", "json", "mmap"},
+	"verify-ids":    {"p", "o", "mmap"},
+	"verify":        {"p", "mmap"},
+	"optimize":      {"p", "n", "top", "align", "force"},
+	"hexheader":     {"p"},
+	"compile":       {"d", "o"},
+	"cat":           {"p", "module"},
+	"list":          {"p", "d", "json", "allow-empty-line"},
+}
 
 func init() {
-	flag.StringVar(&mode, "m", "unpack", "Set mode (pack/unpack/patch)")
+	flag.StringVar(&mode, "m", "unpack", "Set mode (pack/unpack/patch/strings/split/table/check/info/canon/strip/rename/verify-hashes/verify-ids/verify/optimize/hexheader/compile/cat/list)")
 	flag.StringVar(&bundlePath, "p", "", "Set the jsbundle path")
 	flag.StringVar(&outputFilename, "n", "patched.jsbundle", "Set the output filename")
 	flag.StringVar(&outputDir, "o", "out", "Set the output dir")
 	flag.StringVar(&patchesDir, "d", "", "Set the folder for patches")
+	flag.StringVar(&sourceMapPath, "s", "", "Set the source map path")
+	flag.IntVar(&minLength, "min-length", 32, "Set the minimum string literal length for analysis")
+	flag.IntVar(&top, "top", 20, "Set the number of results to show (0 for all)")
+	flag.BoolVar(&jsonOutput, "json", false, "Output results as JSON")
+	flag.BoolVar(&profile, "profile", false, "Print the time spent on each patch")
+	flag.StringVar(&cpuProfilePath, "cpuprofile", "", "Write a cpu profile to file")
+	flag.StringVar(&memProfilePath, "memprofile", "", "Write a memory profile to file")
+	flag.BoolVar(&csvOutput, "csv", false, "Output the entry table as CSV")
+	flag.BoolVar(&absoluteOffsets, "absolute", false, "Show offsets as absolute file positions")
+	flag.BoolVar(&allowEmptyLine, "allow-empty-line", false, "Allow patches to use empty sidecar lines")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what would be written without writing it")
+	flag.StringVar(&naming, "naming", "id", "Set the unpacked file naming (id/path/hash)")
+	flag.StringVar(&mapOutPath, "map-out", "", "Write the original module offsets to a JSON file")
+	flag.StringVar(&layout, "layout", "flat", "Set the unpacked folder layout (flat/graph)")
+	flag.StringVar(&transformNames, "transform", "", "Set the built-in transforms to run after the patches (comma separated)")
+	flag.BoolVar(&embedProvenance, "embed-provenance", false, "Append a record of the applied patches to the bundle")
+	flag.IntVar(&retries, "retries", 0, "Set how many times failed writes are retried")
+	flag.IntVar(&seedIDs, "seed-ids", -1, "Set the first id given to new modules (defaults to the first free id)")
+	flag.StringVar(&stripPaths, "strip-paths", "", "Set the module paths to strip (comma separated)")
+	flag.BoolVar(&force, "force", false, "Replace an existing output folder or bundle")
+	flag.BoolVar(&stripReachable, "strip-reachable", false, "Strip modules even if they can be required from the startup code")
+	flag.BoolVar(&noStartup, "no-startup", false, "Pack the bundle without the startup code")
+	flag.BoolVar(&extractMaps, "extract-maps", false, "Write the inline source maps of the modules next to them")
+	flag.BoolVar(&stripMapComment, "strip-map-comment", false, "Remove the extracted inline source map comments from the modules")
+	flag.DurationVar(&regexTimeout, "regex-timeout", 0, "Give up on a regex patch that takes longer than this on a module (0 for no limit)")
+	flag.StringVar(&selectedModule, "module", "", "Set the id of the module to rename in or print")
+	flag.StringVar(&renameFrom, "from", "", "Set the identifier to rename")
+	flag.StringVar(&renameTo, "to", "", "Set the new name of the identifier")
+	flag.BoolVar(&includeProps, "include-props", false, "Also rename property accesses")
+	flag.StringVar(&compareBundlePath, "compare", "", "Set the bundle the modules are expected to match")
+	flag.StringVar(&hashesPath, "hashes", "", "Set the JSON file of expected module hashes")
+	flag.StringVar(&recordPositionsPath, "record-positions", "", "Write the offset and length change of every replacement to a JSON file")
+	flag.IntVar(&minSize, "min-size", 0, "Only use the modules of at least this many bytes")
+	flag.IntVar(&maxSize, "max-size", -1, "Only use the modules of at most this many bytes (-1 for no limit)")
+	flag.IntVar(&align, "align", 0, "Start each module on a boundary of this many bytes (0 for no alignment)")
+	flag.BoolVar(&checkBalance, "check-balance", false, "Warn when a patch file leaves a module with unbalanced brackets")
+	flag.BoolVar(&useMmap, "mmap", false, "Map the bundle into memory instead of reading each module")
+	flag.BoolVar(&strict, "strict", false, "Fail when a patch or a module find matches nothing")
+	flag.StringVar(&bundleFormat, "format", "indexed", "Set the packed bundle format (indexed/file)")
+	flag.IntVar(&setEntry, "set-entry", -1, "Rewrite the entry call of the startup code to require this module")
+	flag.StringVar(&archiveFormat, "archive-format", "", "Unpack into a tar or zip archive at the -o path instead of a folder")
+	flag.Int64Var(&sourceDateEpoch, "source-date-epoch", -1, "Use this unix time for every emitted timestamp (defaults to $SOURCE_DATE_EPOCH, then the current time)")
+	flag.BoolVar(&explain, "explain", false, "Describe what each patch does without patching a bundle")
+	flag.StringVar(&normalizeLevel, "normalize", "", "Normalize the modules before comparing them (whitespace/idents)")
+	flag.IntVar(&startupLen, "startup-len", -1, "Set the startup length written in the header, up to the startup region length (-1 to compute it)")
+	flag.IntVar(&patchJobs, "j", runtime.NumCPU(), "Set how many modules are patched at once")
+	flag.IntVar(&jobs, "jobs", 4, "Set how many bundles matching a -p glob are processed at once")
+	flag.BoolVar(&countOnly, "count-only", false, "Only print the number of changed, added and removed modules")
+	flag.BoolVar(&warningsAsErrors, "Werror", false, "Treat every warning as an error")
+	flag.BoolVar(&quiet, "quiet", false, "Hide the progress messages and warnings")
+	flag.BoolVar(&esm, "esm", false, "Unpack the modules as ES modules importing each other (can't be packed again)")
+}
 
+// Parse the flags and check they make sense together. The flags are defined in init so their
+// defaults are set in tests too, which parse their own flags
+func parseFlags() {
 	flag.Parse()
 
-	if mode == "unpack" || mode == "patch" {
-		if bundlePath == "" {
-			fmt.Println("Please set the bundle path.")
-			os.Exit(0)
+	allowedFlags, ok := modeFlags[mode]
+	if !ok {
+		fail("Mode not available.\n")
+	}
+
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	// Refuse flags that don't do anything in this mode
+	for name := range setFlags {
+		if !slices.Contains(allowedFlags, name) && !slices.Contains(globalFlags, name) {
+			fail("The -%v flag can't be used in %v mode.\n", name, mode)
 		}
 	}
 
-	if mode == "patch" {
-		if patchesDir == "" {
-			fmt.Println("Please set the patches folder.")
-			os.Exit(0)
+	sizeFilter = setFlags["min-size"] || setFlags["max-size"]
+
+	// Patch mode can read an unpacked folder with -o instead of a bundle
+	patchFolder = mode == "patch" && bundlePath == "" && setFlags["o"]
+
+	// List mode lists the modules of a bundle or the files of a patch suite
+	if mode == "list" && (bundlePath == "") == (patchesDir == "") {
+		fail("Please set either the bundle or the patches folder to list.\n")
+	}
+
+	for _, name := range allowedFlags {
+		if name == "p" && bundlePath == "" && !patchFolder && !explain && mode != "list" {
+			fail("Please set the bundle path.\n")
+		}
+
+		if name == "strip-paths" && stripPaths == "" && !sizeFilter {
+			fail("Please set the paths or the sizes to strip.\n")
+		}
+
+		if name == "module" && mode == "cat" && selectedModule == "" {
+			fail("Please set the module to print.\n")
+		}
+
+		if name == "module" && mode == "rename" && (selectedModule == "" || renameFrom == "" || renameTo == "") {
+			fail("Please set the module, the identifier to rename and its new name.\n")
+		}
+
+		if name == "compare" && (compareBundlePath == "") == (hashesPath == "") {
+			fail("Please set either the bundle to compare with or the expected hashes.\n")
+		}
+
+		if name == "d" && patchesDir == "" && setEntry < 0 && mode != "list" {
+			fail("Please set the patches folder.\n")
+		}
+	}
+
+	if naming != "id" && naming != "path" && naming != "hash" {
+		fail("Naming must be one of id, path or hash.\n")
+	}
+
+	if archiveFormat != "" && archiveFormat != "tar" && archiveFormat != "zip" {
+		fail("Archive format must be one of tar or zip.\n")
+	}
+
+	if bundleFormat != "indexed" && bundleFormat != "file" {
+		fail("Format must be one of indexed or file.\n")
+	}
+
+	// A file RAM bundle has no table and no room after the modules
+	for _, name := range []string{"embed-provenance", "align", "startup-len"} {
+		if bundleFormat == "file" && setFlags[name] {
+			fail("-%v only works with the indexed format.\n", name)
+		}
+	}
+
+	if bundleFormat == "file" && outputFilename == "-" {
+		fail("A file RAM bundle is a folder, it can't be written to stdout.\n")
+	}
+
+	// Follow the SOURCE_DATE_EPOCH convention of reproducible builds when the flag isn't set
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" && !setFlags["source-date-epoch"] {
+		value, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			fail("SOURCE_DATE_EPOCH must be a unix time.\n")
 		}
+
+		sourceDateEpoch = value
 	}
+
+	checkNormalizeLevel()
+
+	if align < 0 {
+		fail("Alignment can't be negative.\n")
+	}
+
+	if layout != "flat" && layout != "graph" {
+		fail("Layout must be one of flat or graph.\n")
+	}
+
+	selectTransforms(transformNames)
+
+	// When patching a bundle the output dir is only used to dump the patched modules
+	dumpPatched = mode == "patch" && bundlePath != "" && setFlags["o"]
+
+	// Rename prints the module unless an output bundle is set
+	renamePack = mode == "rename" && setFlags["n"]
+
+	// The bundle goes to stdout, so the logs go to stderr to keep it intact
+	if outputFilename == "-" {
+		os.Stdout = os.Stderr
+	}
+
+	setupLogger()
 }
 
 func main() {
-	fmt.Println("Starting jsbundletools")
+	parseFlags()
+	defer startProfiling()()
+
+	if !jsonOutput && !csvOutput && !countOnly && mode != "cat" && (mode != "rename" || renamePack) {
+		logger.Println("Starting jsbundletools")
+	}
+
+	if isBatch() {
+		runBatch()
+
+		return
+	}
+
+	if mode == "unpack" {
+		prepareOutputDir()
+	}
+
+	if mode == "unpack" && canStreamUnpack() {
+		if err := unpackStream(); err != nil {
+			fail("%v\n", err)
+		}
+
+		if mapOutPath != "" {
+			writeOffsetMapping()
+		}
+
+		return
+	}
 
 	if mode == "unpack" {
-		modules := readModulesFromBundle()
-		unpack(modules)
+		read := readModulesFromBundle
+		if isFileRAMBundle(bundlePath) {
+			read = func() (*map[string][]byte, error) {
+				return readModulesFromFileRAMBundle(bundlePath)
+			}
+		}
+
+		modules, err := read()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		if err := unpack(modules); err != nil {
+			fail("%v\n", err)
+		}
+
+		if mapOutPath != "" {
+			writeOffsetMapping()
+		}
 
 		return
 	}
 
 	if mode == "pack" {
-		modules := readModulesFromFolder()
-		pack(modules)
+		checkOutputFile(outputFilename)
+
+		if err := packFromFolder(); err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	if mode == "patch" && explain {
+		explainPatches(loadPatches())
 
 		return
 	}
 
 	if mode == "patch" {
-		modules := readModulesFromBundle()
+		// The -o dump is an unpack like any other, the outputs are guarded before patching
+		if dumpPatched {
+			prepareOutputDir()
+		}
+
+		checkOutputFile(outputFilename)
+
+		var modules *map[string][]byte
+		var err error
+
+		if patchFolder {
+			modules, err = readModulesFromManifestFolder()
+		} else {
+			modules, err = readModulesFromBundle()
+		}
+
+		if err != nil {
+			fail("%v\n", err)
+		}
 		patch(modules)
-		pack(modules)
+
+		if dryRun {
+			printPatchMatches()
+
+			return
+		}
+
+		if dumpPatched {
+			if err := unpack(modules); err != nil {
+				fail("%v\n", err)
+			}
+		}
+
+		if err := writeOutput(modules, repack); err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	if mode == "strings" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		analyzeStrings(modules)
+
+		return
+	}
+
+	if mode == "split" {
+		modules := readModulesFromPlainBundle()
+		if err := writeOutput(modules, pack); err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	if mode == "check" {
+		printHeaderSummary()
+
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		checkModules(modules)
+
+		return
+	}
+
+	if mode == "strip" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		stripModules(modules)
+		if err := writeOutput(modules, repack); err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	if mode == "rename" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		renameInModule(modules)
+
+		if renamePack {
+			if err := writeOutput(modules, repack); err != nil {
+				fail("%v\n", err)
+			}
+		}
+
+		return
+	}
+
+	if mode == "verify-hashes" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		verifyHashes(modules)
+
+		return
+	}
+
+	if mode == "verify-ids" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		verifyIDs(modules)
+
+		return
+	}
+
+	if mode == "verify" {
+		verifyRoundtrip()
+
+		return
+	}
+
+	if mode == "optimize" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		order := optimizedOrder(modules)
+		err = writeOutput(modules, func(modules *map[string][]byte, w io.Writer) error {
+			return packChecked(modules, order, w)
+		})
+
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	if mode == "hexheader" {
+		printHexHeader()
+
+		return
+	}
+
+	if mode == "compile" {
+		compilePatchFolder()
+
+		return
+	}
+
+	if mode == "cat" {
+		catModule()
+
+		return
+	}
+
+	if mode == "list" && bundlePath != "" {
+		listModules()
+
+		return
+	}
+
+	if mode == "list" {
+		listPatches()
+
+		return
+	}
+
+	if mode == "canon" {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			fail("%v\n", err)
+		}
+		if err := writeOutput(modules, pack); err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	if mode == "info" {
+		printInfo()
+
+		if sizeFilter {
+			modules, err := readModulesFromBundle()
+			if err != nil {
+				fail("%v\n", err)
+			}
+			printModuleSizes(modules)
+		}
+
+		return
+	}
+
+	if mode == "table" {
+		printEntryTable()
 
 		return
 	}
@@ -105,152 +611,613 @@ func main() {
 	fmt.Println("Mode not available.")
 }
 
-// Write bytes to file at offset
-func writeToFile(file *os.File, data uint32, offset int) {
-	buffer := make([]byte, UINT32_LENGTH)
-	binary.LittleEndian.PutUint32(buffer, data)
-	file.WriteAt(buffer, int64(offset))
-}
+// Read size bytes from offset, a file ending before them is an error since the data would be cut short
+func readFileAtOffset(file BundleSource, offset int, size int) ([]byte, error) {
+	// The sizes come from the header, they're only allocated once they're known to be in the file
+	if int64(offset)+int64(size) > file.Size() {
+		return nil, fmt.Errorf("could not read %v bytes of %v at offset %v: %w", size, file.Name(), offset, io.ErrUnexpectedEOF)
+	}
 
-// Read bytes from a file
-func readFile(file *os.File, offset int) uint32 {
-	bytes := make([]byte, UINT32_LENGTH)
+	bytes := make([]byte, size)
 
-	file.Seek(int64(offset), 0)
+	// ReadFull keeps reading on short reads and reports io.ErrUnexpectedEOF when the file ends first
+	if _, err := io.ReadFull(io.NewSectionReader(file, int64(offset), int64(size)), bytes); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 
-	data, err := file.Read(bytes)
-	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not read %v bytes of %v at offset %v: %w", size, file.Name(), offset, err)
 	}
 
-	return binary.LittleEndian.Uint32(bytes[:data])
+	return bytes, nil
 }
 
-// Read bytes from offset
-func readFileAtOffset(file *os.File, offset int, size int) []byte {
-	bytes := make([]byte, size)
-	file.Seek(int64(offset), 0)
+// Read the bundle header, describing what the file looks like when it isn't a RAM bundle
+func readHeader(r io.ReaderAt) (jsbundle.Header, error) {
+	header, err := jsbundle.ReadHeader(r)
+	if errors.Is(err, jsbundle.ErrMagicNumber) {
+		return header, fmt.Errorf("%w\n%v", err, describeHeader(r))
+	}
+
+	return header, err
+}
 
-	data, err := file.Read(bytes)
+// Read the header and entry table, returning the entries, the start of the modules and the startup length
+func readEntryTable(bundleFile BundleSource) ([]entry, int, int, error) {
+	header, err := readHeader(bundleFile)
 	if err != nil {
-		panic(err)
+		return nil, 0, 0, err
 	}
 
-	return bytes[:data]
-}
+	_, tableEntries, err := jsbundle.ReadEntryTable(bundleFile)
+	if err != nil {
+		return nil, 0, 0, err
+	}
 
-// Check if the file has the magic number
-func checkMagicNumber(magicNumber uint32) {
-	if magicNumber != 0xfb0bd1e5 {
-		fmt.Println("Magic number not found.")
-		os.Exit(0)
+	entries := []entry{}
+	for _, tableEntry := range tableEntries {
+		entries = append(entries, entry{offset: tableEntry.Offset, length: tableEntry.Length})
 	}
+
+	return entries, jsbundle.ModuleStart(header.EntryCount), header.StartupLength, nil
 }
 
 // Read the modules from the bundle and return a modules map
-func readModulesFromBundle() *map[string][]byte {
-	bundleFile, err := os.Open(bundlePath)
+func readModulesFromBundle() (*map[string][]byte, error) {
+	modules, err := readModulesFromBundleFile(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whatever is packed from the modules keeps the byte order of the bundle
+	if byteOrder, err = readBundleByteOrder(bundlePath); err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
+
+// Read the modules of the jsbundle file at path
+func readModulesFromBundleFile(path string) (*map[string][]byte, error) {
+	bundleFile, err := openBundle(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	defer bundleFile.Close()
 
-	modules := map[string][]byte{}
+	entries, moduleStart, startupLength, err := readEntryTable(bundleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	unpack := func() (*jsbundle.Bundle, error) {
+		return jsbundle.Unpack(bundleFile)
+	}
 
-	magicNumber := readFile(bundleFile, 0)
-	checkMagicNumber(magicNumber)
+	// The modules are slices of the mapping
+	// Stdin is already in memory, only files are mapped
+	// A bundle patched over itself is truncated while it's read, so it isn't mapped
+	if file, isFile := bundleFile.(*fileSource); useMmap && isFile {
+		if isOutputFile(file.File) {
+			warn("%v is also the output, it's read normally.", path)
+		} else if mapped, ok := mapFile(file.File); ok {
+			unpack = func() (*jsbundle.Bundle, error) {
+				return jsbundle.UnpackBytes(mapped)
+			}
+		} else {
+			warn("%v could not be mapped, it's read normally.", path)
+		}
+	}
 
-	entryCount := readFile(bundleFile, UINT32_LENGTH)
-	startupCountLength := int(readFile(bundleFile, UINT32_LENGTH*2))
+	bundle, err := unpack()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", bundleFile.Name(), err)
+	}
+
+	// See jsbundle.StartupRegionLength for how the startup code is stored
+	checkTerminator("the startup code", startupLength, bundle.Startup)
+	for index, entry := range entries {
+		checkTerminator(fmt.Sprintf("module %v", index), entry.length, bundle.Modules[index])
+	}
+
+	modules := bundleModules(bundle)
+	if err := checkStartupBoundary(entries, moduleStart, startupLength, bundle.Startup, func(id int) ([]byte, error) {
+		return bundle.Modules[id], nil
+	}); err != nil {
+		return nil, err
+	}
 
-	entries := map[int]entry{}
+	return modules, nil
+}
 
-	entryTableStart := UINT32_LENGTH * 3
-	position := entryTableStart
+// Warn about the startup code or a module read without a NUL terminator. Pack always writes exactly
+// one, so when the data of an entry of length bytes doesn't end with it its last byte was kept
+func checkTerminator(name string, length int, data []byte) {
+	if length > 0 && len(data) == length {
+		warn("%v isn't NUL terminated, its last byte was kept.", name)
+	}
+}
 
-	for entryId := 0; entryId < int(entryCount); entryId++ {
-		entry := entry{
-			offset: int(readFile(bundleFile, position)),
-			length: int(readFile(bundleFile, position+UINT32_LENGTH)),
+// Get the modules of a bundle by id with the startup code, every entry of the table has one so the
+// empty ones are unpacked too
+func bundleModules(bundle *jsbundle.Bundle) *map[string][]byte {
+	modules := map[string][]byte{"startup": bundle.Startup}
+	for id, module := range bundle.Modules {
+		if module == nil {
+			module = []byte{}
 		}
 
-		entries[entryId] = entry
-		position += UINT32_LENGTH * 2
+		modules[strconv.Itoa(id)] = module
 	}
 
-	moduleStart := position
+	return &modules
+}
+
+// Get the bundle of the modules to pack, the ids without a module below the highest one are empty entries
+func modulesBundle(modules *map[string][]byte) *jsbundle.Bundle {
+	bundle := &jsbundle.Bundle{
+		Startup:   (*modules)["startup"],
+		Modules:   make([][]byte, tableEntryCount(slices.Collect(maps.Keys(*modules)))),
+		ByteOrder: byteOrder,
+	}
 
+	for id, module := range *modules {
+		if number, err := strconv.Atoi(id); err == nil && len(module) > 0 {
+			bundle.Modules[number] = module
+		}
+	}
+
+	return bundle
+}
+
+// Warn when the startup code and the first module don't meet where the header says, only the first
+// module is read
+func checkStartupBoundary(entries []entry, moduleStart int, startupLength int, startup []byte, readModule func(id int) ([]byte, error)) error {
+	// Modules normally start right after the startup region
+	firstOffset := -1
+	firstID := -1
 	for index, entry := range entries {
-		start := moduleStart + entry.offset
+		if entry.length > 0 && (firstOffset == -1 || entry.offset < firstOffset) {
+			firstOffset = entry.offset
+			firstID = index
+		}
+	}
 
-		moduleData := readFileAtOffset(bundleFile, start, entry.length)
-		if len(moduleData) > 0 {
-			moduleData = moduleData[:len(moduleData)-1]
+	// A boundary in the middle of the code means the entry module was cut in two
+	if depth := bracketDepth(startup); depth != 0 {
+		warn("the startup code ending at offset %v has unbalanced brackets (%+d), a module may be split between it and the first module.", moduleStart+startupLength, depth)
+	}
+
+	if firstID != -1 {
+		first, err := readModule(firstID)
+		if err != nil {
+			return err
 		}
 
-		modules[strconv.Itoa(index)] = moduleData
+		if !bytes.HasPrefix(bytes.TrimLeft(first, " \t\r\n"), []byte("__d(")) {
+			warn("the first module %v at offset %v doesn't start with a __d call, it may be the end of the startup code.", firstID, moduleStart+firstOffset)
+		}
 	}
 
-	startupSize := (moduleStart + startupCountLength - 1) - moduleStart
-	modules["startup"] = readFileAtOffset(bundleFile, moduleStart, startupSize)
+	if firstOffset > startupLength {
+		warn("%v bytes between the startup code and the first module at offset %v are dropped by canon and optimize.", firstOffset-startupLength, firstOffset)
+	} else if firstOffset != -1 && firstOffset < startupLength {
+		warn("the first module at offset %v overlaps the startup code ending at offset %v.", firstOffset, startupLength)
+	}
 
-	return &modules
+	return nil
 }
 
-// Read the modules from a folder
-func readModulesFromFolder() *map[string][]byte {
+// List the module files of a folder, returns a map of module ids to file paths
+func listModuleFiles() (map[string]string, error) {
+	paths := map[string]string{}
+
+	manifest := readManifest()
+	if manifest != nil && manifest.ExportOnly {
+		fail("%v was unpacked as ES modules or only has some of the modules, it can't be packed again.\n", outputDir)
+	}
+
+	// Use the manifest to map the files back to module ids, flat id named folders can just be listed
+	if manifest != nil && (manifest.Naming != "id" || manifest.Layout != "flat") {
+		files := map[string]string{}
+		for _, id := range slices.Sorted(maps.Keys(manifest.Files)) {
+			name := manifest.Files[id]
+
+			// "startup" is reserved for the startup code, every module has a numeric id
+			if number, err := strconv.Atoi(id); id != "startup" && (err != nil || number < 0) {
+				fail("The manifest of %v lists %v for %v, which isn't a module id or startup.\n", outputDir, name, id)
+			}
+
+			if other, ok := files[filepath.Clean(name)]; ok {
+				fail("The manifest of %v lists %v for both %v and %v.\n", outputDir, name, other, id)
+			}
+
+			files[filepath.Clean(name)] = id
+			addModuleFile(paths, id, filepath.Join(outputDir, name))
+		}
+
+		checkMissingModules(paths)
+		return paths, nil
+	}
+
 	files, err := os.ReadDir(outputDir)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	modules := map[string][]byte{}
-
 	for _, file := range files {
 		if !strings.HasSuffix(file.Name(), ".js") {
 			continue
 		}
 
+		// startup.js is the startup code, the other files are named after their module id
 		id := strings.TrimSuffix(file.Name(), ".js")
-		data, err := os.ReadFile(fmt.Sprintf("%v/%v", outputDir, file.Name()))
+		if number, err := strconv.Atoi(id); id != "startup" && (err != nil || number < 0) {
+			warn("%v isn't named after a module id, it isn't packed.", file.Name())
+			continue
+		}
+
+		addModuleFile(paths, id, filepath.Join(outputDir, file.Name()))
+	}
+
+	checkMissingModules(paths)
+	return paths, nil
+}
+
+// Warn about the module ids below the highest one without a file, the table keeps them as empty
+// entries so the other modules keep their ids. Unpack writes a file even for the empty entries
+func checkMissingModules(paths map[string]string) {
+	missing := []int{}
+	for id := range tableEntryCount(slices.Collect(maps.Keys(paths))) {
+		if _, ok := paths[strconv.Itoa(id)]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 {
+		warn("%v has no file for the modules %v, they're packed as empty entries.", outputDir, formatIDRanges(missing))
+	}
+}
+
+// Format sorted ids as ranges, like 1-3, 7
+func formatIDRanges(ids []int) string {
+	ranges := []string{}
+
+	for start := 0; start < len(ids); {
+		end := start
+		for end+1 < len(ids) && ids[end+1] == ids[end]+1 {
+			end++
+		}
+
+		if end > start {
+			ranges = append(ranges, fmt.Sprintf("%v-%v", ids[start], ids[end]))
+		} else {
+			ranges = append(ranges, strconv.Itoa(ids[start]))
+		}
+
+		start = end + 1
+	}
+
+	return strings.Join(ranges, ", ")
+}
+
+// Add a module file to the list, failing if another file already has the same module id
+func addModuleFile(paths map[string]string, id string, path string) {
+	// 012.js and 12.js are the same module
+	if number, err := strconv.Atoi(id); err == nil {
+		id = strconv.Itoa(number)
+	}
+
+	if existing, ok := paths[id]; ok {
+		fail("Module %v is defined by both %v and %v.\n", id, existing, path)
+	}
+
+	paths[id] = path
+}
+
+// Read the modules from a folder that was unpacked with a manifest
+func readModulesFromManifestFolder() (*map[string][]byte, error) {
+	if readManifest() == nil {
+		return nil, fmt.Errorf("%v has no %v, unpack the bundle again to create one", outputDir, MANIFEST_NAME)
+	}
+
+	return readModulesFromFolder()
+}
+
+// Read the modules from a folder
+func readModulesFromFolder() (*map[string][]byte, error) {
+	modules := map[string][]byte{}
+
+	mapComments := map[string]MapComment{}
+	if manifest := readManifest(); manifest != nil {
+		mapComments = manifest.MapComments
+	}
+
+	files, err := listModuleFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for id, path := range files {
+		data, err := os.ReadFile(path)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+
+		if comment, ok := mapComments[id]; ok {
+			data = restoreMapComment(data, comment)
 		}
 
 		modules[id] = data
 	}
 
-	return &modules
+	return &modules, nil
 }
 
 // Unpack a list of modules to output folder
-func unpack(modules *map[string][]byte) {
-	fmt.Println("Unpacking", bundlePath)
+func unpack(modules *map[string][]byte) error {
+	if !jsonOutput {
+		logger.Println("Unpacking", bundlePath)
+	}
+
+	var writer UnpackWriter
+	if !dryRun {
+		var err error
+		if writer, err = newUnpackWriter(); err != nil {
+			return err
+		}
+
+		defer writer.Abort()
+	}
+
+	files := []UnpackedFile{}
+	names := moduleFileNames(modules, naming)
+	if layout == "graph" {
+		names = graphLayout(modules, names)
+	}
 
-	os.Mkdir(outputDir, 0755)
+	mapComments := map[string]MapComment{}
 
-	for index, content := range *modules {
-		f, err := os.Create(fmt.Sprintf("%v/%v.js", outputDir, index))
+	// Files are written in id order so archives come out the same every time
+	for _, index := range sortedModuleIDs(modules) {
+		content := (*modules)[index]
+
+		// The startup code is always unpacked, only the modules are filtered by size
+		if sizeFilter && index != "startup" && !moduleSizeMatches(content) {
+			delete(names, index)
+			continue
+		}
+
+		path := filepath.Join(outputDir, names[index])
+
+		var sourceMap []byte
+		if extractMaps {
+			if decoded, comment, suffix, ok := extractInlineMap(content); ok {
+				sourceMap = decoded
+
+				// Pack puts the comment back from the manifest
+				if stripMapComment {
+					end := len(content) - suffix
+					content = append(append([]byte{}, content[:end-len(comment)]...), content[end:]...)
+					mapComments[index] = MapComment{Comment: string(comment), Suffix: suffix}
+				}
+			}
+		}
+
+		if esm && index != "startup" {
+			content = moduleToESM(index, content, names)
+		}
+
+		files = append(files, UnpackedFile{ID: index, Path: path, Size: len(content)})
+
+		if dryRun {
+			continue
+		}
+
+		if sourceMap != nil {
+			if err := writer.WriteFile(names[index]+".map", sourceMap); err != nil {
+				return err
+			}
+		}
+
+		if err := writer.WriteFile(names[index], content); err != nil {
+			return err
+		}
+	}
+
+	if !dryRun {
+		padding, err := readBundlePadding()
 		if err != nil {
-			panic(err)
+			return err
 		}
 
-		f.WriteString(string(content))
-		f.Close()
+		header, err := readManifestHeader()
+		if err != nil {
+			return err
+		}
+
+		if err := writeManifest(writer, Manifest{Naming: naming, Layout: layout, ExportOnly: esm || sizeFilter, Files: names, MapComments: mapComments, Padding: padding, BigEndian: byteOrder == binary.BigEndian, Header: header}); err != nil {
+			return err
+		}
+
+		if err := writer.Close(); err != nil {
+			return err
+		}
 	}
 
-	fmt.Println("Done!")
+	if jsonOutput {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+
+		output, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if dryRun {
+		fmt.Printf("Would write %v files to %v\n", len(files), outputDir)
+		return nil
+	}
+
+	logger.Println("Done!")
+
+	return nil
 }
 
 // Apply patches a list of modules
 func patch(modules *map[string][]byte) {
+	patches := []PatchInfo{}
+	if patchesDir != "" {
+		patches = loadPatches()
+	}
+	modulePaths := readModulePaths()
+
+	insertNewModules(modules, patches)
+
+	for _, info := range patches {
+		removeModules(modules, info)
+	}
+
+	startupOnly := len(patches) > 0
+	for _, info := range patches {
+		startupOnly = startupOnly && info.Target == "startup"
+	}
+
+	if startupOnly {
+		logger.Println("All patches target the startup code, skipping the modules.")
+	}
+
+	// Stale patches and module finds, reported once every patch file was applied
+	unmatched := []string{}
+	set := moduleSet{modules: modules, paths: modulePaths}
+
+	for _, info := range patches {
+		if info.Modules != nil && info.Modules.Find != nil {
+			logger.Printf("Finding modules for %v\n", info.Name)
+		}
+
+		imports, unmatchedFinds, err := jsbundle.ResolveImports(set, sortedModuleIDs(modules), info.Modules)
+		if err != nil {
+			fail("Patch %v can't be applied, %v.\n", info.Name, err)
+		}
+
+		for _, moduleFind := range unmatchedFinds {
+			unmatched = append(unmatched, fmt.Sprintf("the module find %q of %v", moduleFind, info.Name))
+		}
+
+		// Keep the modules as they were before this patch file to compare their bracket balance
+		unpatched := map[string][]byte{}
+		if checkBalance {
+			maps.Copy(unpatched, *modules)
+		}
+
+		applyDependencyOps(modules, info)
+
+		logger.Printf("Applying patches for %v\n", info.Name)
+		appliedPatches = append(appliedPatches, info.Name)
+		registerPatchMatches(info)
+
+		file := jsbundle.PatchFile{Name: info.Name, Target: info.Target, Imports: imports}
+		for _, patch := range info.Patches {
+			file.Patches = append(file.Patches, patch.CompiledPatch)
+		}
+
+		report, err := jsbundle.ApplyPatchFile(set, sortedModuleIDs(modules), file, jsbundle.PatchOptions{
+			Jobs: patchJobs,
+			Run: func(patchIndex int, moduleID string, step func()) {
+				runPatchStep(info, patchIndex, moduleID, step)
+			},
+		})
+
+		if err != nil {
+			fail("%v, nothing was written.\n", err)
+		}
+
+		for _, moduleID := range report.ImportFailed {
+			warn("module %v patched by %v doesn't have a __d wrapper with a dependency map argument, it was patched without its imports.", moduleID, info.Name)
+		}
+
+		for patchIndex, patchReport := range report.Patches {
+			patch := info.Patches[patchIndex]
+
+			for _, moduleID := range patchReport.Matched {
+				recordChangePositions(info.Name, patchIndex, moduleID, patchReport.Changes[moduleID])
+				recordPatchMatches(info.Name, patchIndex, moduleID, len(patchReport.Changes[moduleID]))
+			}
+
+			if len(patchReport.Matched) > 0 {
+				changingPatches++
+			}
+
+			if patch.ExpectedCount != nil {
+				logger.Printf("Patch %v#%v replaced %v occurrences\n", info.Name, patchIndex, patchReport.Replacements)
+				continue
+			}
+
+			if patchReport.AlreadyApplied > 0 {
+				logger.Printf("Patch %v#%v was already applied to %v modules\n", info.Name, patchIndex, patchReport.AlreadyApplied)
+			}
+
+			if patchReport.GuardSkipped > 0 {
+				logger.Printf("Patch %v#%v skipped %v matching modules because of requires/excludesIf\n", info.Name, patchIndex, patchReport.GuardSkipped)
+			}
+
+			if patch.AllModules {
+				logger.Printf("Patch %v#%v replaced %v occurrences across %v modules\n", info.Name, patchIndex, patchReport.Replacements, len(patchReport.Matched))
+			} else if len(patchReport.Matched) > 1 {
+				warn("patch %v#%v matched %v modules, set allModules if this is intended", info.Name, patchIndex, len(patchReport.Matched))
+			}
+
+			// Already applied and guarded patches did find their text
+			if len(patchReport.Matched)+patchReport.AlreadyApplied+patchReport.GuardSkipped == 0 {
+				unmatched = append(unmatched, fmt.Sprintf("patch %v#%v", info.Name, patchIndex))
+			}
+		}
+
+		if report.SkippedImports > 0 {
+			logger.Printf("Skipped %v imports of %v that were already injected\n", report.SkippedImports, info.Name)
+		}
+
+		if checkBalance {
+			checkBracketBalance(modules, unpatched, info.Name)
+		}
+	}
+
+	if setEntry >= 0 {
+		setEntryModule(modules, setEntry)
+	}
+
+	if len(unmatched) > 0 && strict {
+		fail("Nothing was written, these matched nothing:\n    %v\n", strings.Join(unmatched, "\n    "))
+	}
+
+	for _, description := range unmatched {
+		warn("%v matched nothing, it may be stale", description)
+	}
+
+	applyTransforms(modules)
+	writeChangePositions()
+	writeBatchReport()
+
+	logger.Println("Patches were applied!")
+	printPatchTimings()
+}
+
+// Load the patch files of the patches folder, with their regexes and sidecar lines
+func loadPatches() []PatchInfo {
 	patchesFolders, err := os.ReadDir(patchesDir)
 	if err != nil {
-		panic(err)
+		fail("%v\n", err)
 	}
 
 	patches := []PatchInfo{}
+	modulePaths := readModulePaths()
 
 	for _, patchFile := range patchesFolders {
 		if !strings.HasSuffix(patchFile.Name(), ".json") {
@@ -259,154 +1226,280 @@ func patch(modules *map[string][]byte) {
 
 		patchFileContent, err := os.ReadFile(fmt.Sprintf("%v/%v", patchesDir, patchFile.Name()))
 		if err != nil {
-			panic(err)
+			fail("%v\n", err)
 		}
 
 		var info PatchInfo
-		json.Unmarshal(patchFileContent, &info)
-		info.Name = strings.Replace(patchFile.Name(), ".json", "", -1)
+		if err := json.Unmarshal(patchFileContent, &info); err != nil {
+			fail("Patch %v can't be loaded, %v.\n", patchFile.Name(), err)
+		}
+
+		info.Name = strings.TrimSuffix(patchFile.Name(), filepath.Ext(patchFile.Name()))
+
+		if err := jsbundle.CheckTarget(info.Target, info.Modules != nil); err != nil {
+			fail("Patch %v can't be loaded, %v.\n", info.Name, err)
+		}
+
+		sidecarPath := filepath.Join(patchesDir, info.Name+".js")
+		if info.Sidecar != nil {
+			sidecarPath = filepath.Join(patchesDir, *info.Sidecar)
+		}
 
 		for index, patch := range info.Patches {
-			// Load regex patch
-			if patch.Rfind != nil {
-				info.Patches[index].FindRegex = regexp.MustCompile(*patch.Rfind)
-				find := strings.Replace(*patch.Rfind, "\\", "", -1)
-				info.Patches[index].Find = &find
+			resolved, err := jsbundle.SubstituteVars(info.Vars, patch.Patch)
+			if err != nil {
+				fail("Patch %v#%v can't be loaded, %v.\n", info.Name, index, err)
 			}
 
 			// Try to load replace values
-			if patch.Replace == nil {
-				if patch.FReplace != nil || patch.Fappend != nil {
-					jsContent, err := os.ReadFile(fmt.Sprintf("%v/%v", patchesDir, strings.Replace(patchFile.Name(), ".json", ".js", 1)))
-					if err != nil {
-						panic(err)
-					}
-
-					lines := strings.Split(string(jsContent), "\n")
-
-					if patch.FReplace != nil {
-						info.Patches[index].Replace = &lines[*patch.FReplace]
-					}
-
-					if patch.Fappend != nil {
-						replace := *info.Patches[index].Find + lines[*patch.Fappend]
-						info.Patches[index].Replace = &replace
-					}
+			if resolved.Replace == nil && resolved.Append == nil && (patch.FReplace != nil || patch.Fappend != nil) {
+				lines := readSidecarLines(sidecarPath, patchFile.Name())
+
+				if patch.Fappend != nil {
+					line := sidecarLine(lines, sidecarPath, *patch.Fappend)
+					resolved.Append = &line
+				} else {
+					line := sidecarLine(lines, sidecarPath, *patch.FReplace)
+					resolved.Replace = &line
 				}
+			}
 
-				if patch.Append != nil {
-					replace := *info.Patches[index].Find + *patch.Append
-					info.Patches[index].Replace = &replace
-				}
+			patch.CompiledPatch, err = jsbundle.CompilePatch(resolved)
+			if err != nil {
+				fail("Patch %v#%v can't be loaded, %v.\n", info.Name, index, err)
+			}
+
+			// Path scoping needs the module paths of a source map
+			if patch.PathRegex != nil && modulePaths == nil && !explain {
+				warn("no source map provided, path scoping was ignored for %v", info.Name)
+				patch.PathRegex = nil
 			}
+
+			info.Patches[index] = patch
 		}
 
 		patches = append(patches, info)
 	}
 
-	moduleFindRegex := regexp.MustCompile("__d\\(function\\(g,r,i,a,m,e,d\\){(.*)},(.*),\\[(.*)\\]\\)")
+	return patches
+}
 
+// Add the new modules of the patches to the modules with freshly allocated ids
+func insertNewModules(modules *map[string][]byte, patches []PatchInfo) {
+	nextID := seedIDs
+	if nextID < 0 {
+		nextID = 0
+		for moduleID := range *modules {
+			if number, err := strconv.Atoi(moduleID); err == nil && number >= nextID {
+				nextID = number + 1
+			}
+		}
+	}
+
+	insert := func(id int, newModule NewModuleData, name string) {
+		deps := []string{}
+		for _, dep := range newModule.Deps {
+			deps = append(deps, strconv.Itoa(dep))
+		}
+
+		(*modules)[strconv.Itoa(id)] = []byte(fmt.Sprintf("__d(function(g,r,i,a,m,e,d){%v},%v,[%v])", newModule.Body, id, strings.Join(deps, ",")))
+		logger.Printf("Inserted module %v for %v\n", id, name)
+	}
+
+	// The modules with an id go first so the free ids are picked around them
+	insertedBy := map[int]string{}
 	for _, info := range patches {
-		if info.Modules != nil && info.Modules.Find != nil {
-			fmt.Printf("Finding modules for %v\n", info.Name)
+		for _, newModule := range info.NewModules {
+			if newModule.ID == nil {
+				continue
+			}
 
-			for _, moduleFind := range *info.Modules.Find {
-				for moduleID := range *modules {
-					module := (*modules)[moduleID]
+			id := *newModule.ID
+			if id < 0 {
+				fail("Module %v inserted by %v doesn't have a valid id.\n", id, info.Name)
+			}
 
-					if strings.Contains(string(module), moduleFind) {
-						info.Modules.ToImport = append(info.Modules.ToImport, moduleID)
-						break
-					}
-				}
+			if other, ok := insertedBy[id]; ok {
+				fail("Module %v is inserted by both %v and %v.\n", id, other, info.Name)
+			}
+
+			// An empty entry is a hole of the table and can be filled
+			if len((*modules)[strconv.Itoa(id)]) > 0 {
+				fail("Module %v inserted by %v already exists.\n", id, info.Name)
 			}
+
+			insertedBy[id] = info.Name
+			insert(id, newModule, info.Name)
 		}
+	}
 
-		fmt.Printf("Applying patches for %v\n", info.Name)
-		for moduleID := range *modules {
-			for _, patch := range info.Patches {
-				applyModules := func() {
-					if info.Modules != nil {
-						for index, moduleImportID := range info.Modules.ToImport {
-							matches := moduleFindRegex.FindAllStringSubmatch(string((*modules)[moduleID]), -1)
-							moduleCode := matches[0][1]
-
-							modulesArray := matches[0][3]
-							modulesArrayLength := len(strings.Split(modulesArray, ","))
-
-							(*modules)[moduleID] = []byte(strings.ReplaceAll(string((*modules)[moduleID]), moduleCode, fmt.Sprintf("var cmod%v=r(d[%v]);", index+1, modulesArrayLength)+moduleCode))
-							(*modules)[moduleID] = []byte(strings.ReplaceAll(string((*modules)[moduleID]), modulesArray, modulesArray+fmt.Sprintf(",%v", moduleImportID)))
-						}
-					}
-				}
+	for _, info := range patches {
+		for _, newModule := range info.NewModules {
+			if newModule.ID != nil {
+				continue
+			}
 
-				if patch.FindRegex != nil && patch.FindRegex.Match((*modules)[moduleID]) || strings.Contains(string((*modules)[moduleID]), *patch.Find) {
-					applyModules()
-					if patch.FindRegex != nil {
-						(*modules)[moduleID] = []byte(patch.FindRegex.ReplaceAllString(string((*modules)[moduleID]), *patch.Replace))
-					} else {
-						(*modules)[moduleID] = []byte(strings.ReplaceAll(string((*modules)[moduleID]), *patch.Find, *patch.Replace))
-					}
+			for {
+				if _, exists := (*modules)[strconv.Itoa(nextID)]; !exists {
+					break
 				}
+
+				nextID++
 			}
+
+			insert(nextID, newModule, info.Name)
+			nextID++
 		}
 	}
+}
 
-	fmt.Println("Patches were applied!")
+// The modules map as the jsbundle patch engine sees it, with the module paths of the source map
+type moduleSet struct {
+	modules *map[string][]byte
+	paths   map[string]string
 }
 
-// Pack a list of modules into a jsbundle file
-func pack(modules *map[string][]byte) {
-	fmt.Println("Repacking jsbundle.")
+func (m moduleSet) Module(id string) []byte {
+	return (*m.modules)[id]
+}
 
-	startup := (*modules)["startup"]
-	delete(*modules, "startup")
+func (m moduleSet) SetModule(id string, code []byte) {
+	(*m.modules)[id] = code
+}
 
-	entries := map[string]entry{}
-	offset := len(startup) + 1
+func (m moduleSet) Path(id string) (string, bool) {
+	path, ok := m.paths[id]
+	return path, ok
+}
+
+// Get the number of table entries needed for the module ids, missing ids are left as empty entries
+func tableEntryCount(ids []string) int {
+	count := 0
 
-	for moduleId, content := range *modules {
-		entries[moduleId] = entry{
-			offset: offset,
-			length: len(content) + 1,
+	for _, id := range ids {
+		if number, err := strconv.Atoi(id); err == nil && number >= count {
+			count = number + 1
 		}
+	}
+
+	return count
+}
+
+// Pack a list of modules into a jsbundle written to w
+func pack(modules *map[string][]byte, w io.Writer) error {
+	return packInOrder(modules, nil, Padding{}, nil, w)
+}
 
-		offset += entries[moduleId].length
+// Pack the modules to w keeping the layout of the input and the bytes it had outside of them, so an
+// untouched bundle comes out byte for byte identical
+func repack(modules *map[string][]byte, w io.Writer) error {
+	padding := Padding{}
+	var header *ManifestHeader
+
+	if patchFolder {
+		if manifest := readManifest(); manifest != nil {
+			header = manifest.Header
+
+			if manifest.Padding != nil {
+				padding = *manifest.Padding
+			}
+		}
+	} else {
+		bundlePadding, err := readBundlePadding()
+		if err != nil {
+			return err
+		}
+
+		if bundlePadding != nil {
+			padding = *bundlePadding
+		}
+
+		if header, err = readBundleLayout(bundlePath); err != nil {
+			return err
+		}
+	}
+
+	return packInOrder(modules, nil, padding, header, w)
+}
+
+// Pack the modules to w with their data laid out in the order of ids, or in id order when ids is nil.
+// The table is always in id order. The modules go back where header had them while they keep their
+// size. Files are written in place, any other writer gets the bundle in one go once it's complete
+func packInOrder(modules *map[string][]byte, ids []string, padding Padding, header *ManifestHeader, w io.Writer) error {
+	bundle := modulesBundle(modules)
+	if noStartup {
+		bundle.Startup = nil
+	}
+
+	if len(bundle.Startup) == 0 {
+		warn("the bundle has no startup code, the runtime won't require any module by itself.")
 	}
 
-	entryCount := len(entries)
-	length := offset + UINT32_LENGTH*3 + entryCount*2*UINT32_LENGTH
+	layout := packLayout(padding, header)
+	for _, id := range ids {
+		if number, err := strconv.Atoi(id); err == nil {
+			layout.Order = append(layout.Order, number)
+		}
+	}
 
-	outputFile, err := os.Create(outputFilename)
+	_, _, length, err := bundle.Place(layout)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	os.Truncate(outputFilename, int64(length))
+	outputFile, ok := w.(BundleOutput)
+	if file, isFile := w.(*os.File); isFile {
+		checkDiskSpace(file.Name(), length)
+	} else if !ok {
+		outputFile = &memoryOutput{}
+	}
 
-	defer outputFile.Close()
+	// The file can be the bundle that was read, it's only emptied now that everything was read from it
+	if err := outputFile.Truncate(0); err != nil {
+		return err
+	}
 
-	writeToFile(outputFile, 0xfb0bd1e5, 0)
-	writeToFile(outputFile, uint32(entryCount), UINT32_LENGTH)
-	writeToFile(outputFile, uint32(len(startup)+1), UINT32_LENGTH*2)
+	if err := outputFile.Truncate(int64(length)); err != nil {
+		return err
+	}
 
-	tableStart := UINT32_LENGTH * 3
-	moduleStart := tableStart + entryCount*UINT32_LENGTH*2
-	position := tableStart
+	if _, err := bundle.PackLayout(retryWriter{outputFile}, layout); err != nil {
+		return err
+	}
 
-	for i := 0; i < len(entries); i++ {
-		entryId := strconv.Itoa(i)
-		entry := entries[entryId]
+	if embedProvenance {
+		if err := writeProvenance(outputFile, length); err != nil {
+			return err
+		}
+	}
+
+	if buffer, ok := outputFile.(*memoryOutput); ok {
+		_, err := w.Write(buffer.data)
+		return err
+	}
 
-		writeToFile(outputFile, uint32(entry.offset), position)
-		writeToFile(outputFile, uint32(entry.length), position+UINT32_LENGTH)
-		position += UINT32_LENGTH * 2
+	return nil
+}
 
-		outputFile.WriteAt((*modules)[entryId], int64(moduleStart+entry.offset))
+// Get the layout of a packed bundle from the flags, the padding to keep and the header of the
+// bundle the modules come from
+func packLayout(padding Padding, header *ManifestHeader) jsbundle.Layout {
+	layout := jsbundle.Layout{Align: align, AfterStartup: padding.AfterStartup, Trailing: padding.Trailing}
+	if startupLen >= 0 {
+		layout.StartupLength = &startupLen
 	}
 
-	outputFile.WriteAt(startup, int64(moduleStart))
-	outputFile.WriteAt([]byte{0}, int64(moduleStart+len(startup)))
+	// Without its startup code the bundle can't keep the original layout
+	if header != nil && !noStartup {
+		layout.OriginalStartupLength = header.StartupLength
+		layout.OriginalUnterminatedStartup = header.UnterminatedStartup
+		layout.OriginalEntries = []jsbundle.Entry{}
+
+		for _, original := range header.Entries {
+			layout.OriginalEntries = append(layout.OriginalEntries, jsbundle.Entry{Offset: original.Offset, Length: original.Length})
+			layout.OriginalUnterminated = append(layout.OriginalUnterminated, original.Unterminated)
+		}
+	}
 
-	fmt.Println("jsbundle has been created")
+	return layout
 }
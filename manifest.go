@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const MANIFEST_NAME = "manifest.json"
+
+// Bump when the manifest changes in a way older versions of the tool can't read
+const MANIFEST_VERSION = 4
+
+type Manifest struct {
+	Version     int                   `json:"version"`
+	ToolVersion string                `json:"toolVersion"`
+	Naming      string                `json:"naming"`
+	Layout      string                `json:"layout"`
+	ExportOnly  bool                  `json:"exportOnly"`
+	Files       map[string]string     `json:"files"`
+	MapComments map[string]MapComment `json:"mapComments,omitempty"`
+	Padding     *Padding              `json:"padding,omitempty"`
+	BigEndian   bool                  `json:"bigEndian,omitempty"`
+	Header      *ManifestHeader       `json:"header,omitempty"`
+}
+
+// The header and entry table of the unpacked bundle, so pack can lay the modules out where they were
+type ManifestHeader struct {
+	Magic         string          `json:"magic"`
+	Format        string          `json:"format"`
+	EntryCount    int             `json:"entryCount"`
+	StartupLength int             `json:"startupLength"`
+	Entries       []ManifestEntry `json:"entries"`
+
+	// The startup region had no NUL, pack puts it back without one
+	UnterminatedStartup bool `json:"unterminatedStartup,omitempty"`
+}
+
+type ManifestEntry struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+
+	// The module had no NUL, pack puts it back without one
+	Unterminated bool `json:"unterminated,omitempty"`
+}
+
+type MapComment struct {
+	Comment string `json:"comment"`
+	Suffix  int    `json:"suffix"`
+}
+
+// Sort the module ids numerically, with the startup code first
+func sortedModuleIDs(modules *map[string][]byte) []string {
+	ids := []string{}
+	for id := range *modules {
+		ids = append(ids, id)
+	}
+
+	sortModuleIDs(ids)
+
+	return ids
+}
+
+// Sort module ids in place numerically, with the startup code first
+func sortModuleIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i] == "startup" || ids[j] == "startup" {
+			return ids[i] == "startup"
+		}
+
+		a, errA := strconv.Atoi(ids[i])
+		b, errB := strconv.Atoi(ids[j])
+		if errA != nil || errB != nil {
+			return ids[i] < ids[j]
+		}
+
+		return a < b
+	})
+}
+
+// Hash the content of a module
+func moduleHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Make a source path safe to use as a path inside the output folder
+func sanitizeModulePath(path string) string {
+	path = filepath.ToSlash(filepath.Clean("/" + path))
+	return strings.TrimPrefix(path, "/")
+}
+
+// Pick the file name of each module for the naming scheme
+func moduleFileNames(modules *map[string][]byte, naming string) map[string]string {
+	modulePaths := map[string]string{}
+	if naming == "path" {
+		modulePaths = readModulePaths()
+		if modulePaths == nil {
+			warn("no source map provided, falling back to id naming.")
+		}
+	}
+
+	names := map[string]string{}
+	used := map[string]bool{}
+
+	for _, id := range sortedModuleIDs(modules) {
+		name := id
+		if id != "startup" {
+			switch naming {
+			case "path":
+				if path, ok := modulePaths[id]; ok {
+					name = sanitizeModulePath(path)
+				}
+			case "hash":
+				name = moduleHash((*modules)[id])[:12]
+			}
+		}
+
+		// Identical names get the module id appended, lowest id keeps the plain name
+		if used[name+".js"] {
+			name = fmt.Sprintf("%v-%v", name, id)
+		}
+
+		used[name+".js"] = true
+		names[id] = name + ".js"
+	}
+
+	return names
+}
+
+// Read the header and entry table of the bundle for the manifest. Only unpack records them, the
+// modules dumped by patch mode don't have the original layout anymore
+func readManifestHeader() (*ManifestHeader, error) {
+	if mode != "unpack" {
+		return nil, nil
+	}
+
+	return readBundleLayout(bundlePath)
+}
+
+// Read the header and entry table of a bundle, nil for a file RAM bundle which has neither
+func readBundleLayout(path string) (*ManifestHeader, error) {
+	if isFileRAMBundle(path) {
+		return nil, nil
+	}
+
+	bundleFile, err := openBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer bundleFile.Close()
+
+	header, err := readHeader(bundleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, moduleStart, _, err := readEntryTable(bundleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	unterminatedStartup, err := isUnterminated(bundleFile, moduleStart, header.StartupLength)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestHeader := &ManifestHeader{
+		Magic:         fmt.Sprintf("0x%08x", header.Magic),
+		Format:        "indexed",
+		EntryCount:    header.EntryCount,
+		StartupLength: header.StartupLength,
+		Entries:       []ManifestEntry{},
+
+		UnterminatedStartup: unterminatedStartup,
+	}
+
+	for _, entry := range entries {
+		unterminated, err := isUnterminated(bundleFile, moduleStart+entry.offset, entry.length)
+		if err != nil {
+			return nil, err
+		}
+
+		manifestHeader.Entries = append(manifestHeader.Entries, ManifestEntry{Offset: entry.offset, Length: entry.length, Unterminated: unterminated})
+	}
+
+	return manifestHeader, nil
+}
+
+// Tell whether the region of length bytes at offset doesn't end with a NUL, an empty region has none
+func isUnterminated(bundleFile BundleSource, offset int, length int) (bool, error) {
+	if length == 0 {
+		return false, nil
+	}
+
+	last, err := readFileAtOffset(bundleFile, offset+length-1, 1)
+	if err != nil {
+		return false, err
+	}
+
+	return last[0] != 0, nil
+}
+
+// Write the manifest next to the unpacked files
+func writeManifest(writer UnpackWriter, manifest Manifest) error {
+	manifest.Version = MANIFEST_VERSION
+	manifest.ToolVersion = VERSION
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteFile(MANIFEST_NAME, append(content, '\n'))
+}
+
+// Read the manifest from the output folder, returns nil if there's none
+func readManifest() *Manifest {
+	content, err := os.ReadFile(filepath.Join(outputDir, MANIFEST_NAME))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		fail("Could not parse %v: %v\n", MANIFEST_NAME, err)
+	}
+
+	if manifest.Version > MANIFEST_VERSION {
+		fail("Manifest version %v was written by jsbundletools %v and isn't supported by this version, update the tool or unpack the bundle again.\n", manifest.Version, manifest.ToolVersion)
+	}
+
+	migrateManifest(&manifest)
+
+	return &manifest
+}
+
+// Bring a manifest written by an older version of the tool up to the current version
+func migrateManifest(manifest *Manifest) {
+	// Manifests from before the version field have the same fields but may miss the defaults
+	if manifest.Version == 0 {
+		if manifest.Naming == "" {
+			manifest.Naming = "id"
+		}
+
+		if manifest.Layout == "" {
+			manifest.Layout = "flat"
+		}
+
+		manifest.Version = 1
+	}
+
+	// Version 2 added the padding, version 1 folders don't have any
+	if manifest.Version == 1 {
+		manifest.Version = 2
+	}
+
+	// Version 3 added bigEndian, version 2 folders were all unpacked from little endian bundles
+	if manifest.Version == 2 {
+		manifest.Version = 3
+	}
+
+	// Version 4 added the header, pack lays the modules of older folders out in id order
+	if manifest.Version == 3 {
+		manifest.Version = 4
+	}
+}
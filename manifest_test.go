@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadManifestMigrates(t *testing.T) {
+	defer func(dir string) { outputDir = dir }(outputDir)
+
+	tests := []struct {
+		name      string
+		content   string
+		naming    string
+		bigEndian bool
+		header    bool
+	}{
+		{"unversioned", `{"files":{"0":"0.js"}}`, "id", false, false},
+		{"version 1", `{"version":1,"naming":"path","layout":"flat","files":{"0":"0.js"}}`, "path", false, false},
+		{"version 2", `{"version":2,"naming":"id","layout":"flat","files":{"0":"0.js"},"padding":{"trailing":"AAA="}}`, "id", false, false},
+		{"version 3", `{"version":3,"naming":"id","layout":"flat","files":{"0":"0.js"},"bigEndian":true}`, "id", true, false},
+		{"version 4", `{"version":4,"naming":"id","layout":"flat","files":{"0":"0.js"},"header":{"magic":"0xfb0bd1e5","format":"indexed","entryCount":1,"startupLength":0,"entries":[{"offset":0,"length":8}]}}`, "id", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputDir = t.TempDir()
+			if err := os.WriteFile(filepath.Join(outputDir, MANIFEST_NAME), []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			manifest := readManifest()
+			if manifest.Version != MANIFEST_VERSION {
+				t.Errorf("version is %v, want %v", manifest.Version, MANIFEST_VERSION)
+			}
+
+			if manifest.Naming != test.naming || manifest.Layout != "flat" {
+				t.Errorf("naming and layout are %v and %v, want %v and flat", manifest.Naming, manifest.Layout, test.naming)
+			}
+
+			if manifest.BigEndian != test.bigEndian {
+				t.Errorf("bigEndian is %v, want %v", manifest.BigEndian, test.bigEndian)
+			}
+
+			if (manifest.Header != nil) != test.header {
+				t.Errorf("the header is %+v, want one: %v", manifest.Header, test.header)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "os"
+
+// Files aren't mapped on this platform, they're read like without -mmap
+func mapFile(file *os.File) ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Map the whole file read-only, the mapping stays until the process exits
+func mapFile(file *os.File) ([]byte, bool) {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, false
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
@@ -0,0 +1,78 @@
+package main
+
+import "slices"
+
+var javascriptKeywords = []string{
+	"break", "case", "catch", "class", "const", "continue", "debugger", "default", "delete", "do",
+	"else", "export", "extends", "false", "finally", "for", "function", "if", "import", "in",
+	"instanceof", "let", "new", "null", "return", "super", "switch", "this", "throw", "true", "try",
+	"typeof", "undefined", "var", "void", "while", "with", "yield", "async", "await", "of",
+}
+
+// Normalize a module for comparing, whitespace drops comments and insignificant whitespace, idents
+// also masks identifiers and numbers so renamed variables don't show up as changes
+func normalizeModule(content []byte, level string) []byte {
+	normalized := []byte{}
+	space := false
+
+	// Two identifiers or numbers still need a space between them
+	appendToken := func(token []byte) {
+		if space && len(normalized) > 0 && isIdentifierByte(normalized[len(normalized)-1]) && isIdentifierByte(token[0]) {
+			normalized = append(normalized, ' ')
+		}
+
+		normalized = append(normalized, token...)
+		space = false
+	}
+
+	position := 0
+	for position < len(content) {
+		c := content[position]
+
+		switch {
+		case c == '/' && position+1 < len(content) && (content[position+1] == '/' || content[position+1] == '*'):
+			position = skipLiteral(content, position)
+			space = true
+		case c == '"' || c == '\'' || c == '`':
+			end := skipLiteral(content, position)
+			appendToken(content[position:min(end, len(content))])
+			position = end
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			position++
+			space = true
+		case isIdentifierByte(c):
+			end := position
+			for end < len(content) && isIdentifierByte(content[end]) {
+				end++
+			}
+
+			token := content[position:end]
+			if level == "idents" {
+				if c >= '0' && c <= '9' {
+					token = []byte("0")
+				} else if !slices.Contains(javascriptKeywords, string(token)) && !isPropertyAccess(content, position) {
+					token = []byte("_")
+				}
+			}
+
+			appendToken(token)
+			position = end
+		default:
+			appendToken(content[position : position+1])
+			position++
+		}
+	}
+
+	return normalized
+}
+
+// Check the -normalize level
+func checkNormalizeLevel() {
+	if normalizeLevel != "" && normalizeLevel != "whitespace" && normalizeLevel != "idents" {
+		fail("Normalize must be one of whitespace or idents.\n")
+	}
+
+	if normalizeLevel != "" && hashesPath != "" {
+		fail("Modules can only be normalized when comparing with another bundle.\n")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// Order the module data by access order and report the modules that moved. Holes have no data, they
+// stay out of the order and the report
+func optimizedOrder(modules *map[string][]byte) []string {
+	isHole := func(moduleID string) bool {
+		return len((*modules)[moduleID]) == 0
+	}
+
+	order := slices.DeleteFunc(accessOrder(modules), isHole)
+	original := slices.DeleteFunc(sortedModuleIDs(modules), func(moduleID string) bool {
+		return moduleID == "startup" || isHole(moduleID)
+	})
+	reachable := reachableModules(modules)
+
+	moved := 0
+	shown := 0
+	for position, moduleID := range order {
+		if original[position] == moduleID {
+			continue
+		}
+
+		moved++
+		if top <= 0 || shown < top {
+			logger.Printf("Module %v placed at position %v\n", moduleID, position)
+			shown++
+		}
+	}
+
+	logger.Printf("%v modules are reachable from the startup code and placed first, %v modules moved\n", len(reachable), moved)
+
+	return order
+}
+
+// Pack the modules with their data in order into memory, and only write the bundle to w once every
+// module reads back from it with the same hash
+func packChecked(modules *map[string][]byte, order []string, w io.Writer) error {
+	packed := &bytes.Buffer{}
+	if err := packInOrder(modules, order, Padding{}, nil, packed); err != nil {
+		return err
+	}
+
+	bundle, err := jsbundle.UnpackBytes(packed.Bytes())
+	if err != nil {
+		return err
+	}
+
+	repacked := bundleModules(bundle)
+	changed := []string{}
+	for _, moduleID := range sortedModuleIDs(repacked) {
+		if moduleHash((*modules)[moduleID]) != moduleHash((*repacked)[moduleID]) {
+			changed = append(changed, moduleID)
+		}
+	}
+
+	for moduleID := range *modules {
+		if _, ok := (*repacked)[moduleID]; !ok && len((*modules)[moduleID]) > 0 {
+			changed = append(changed, moduleID)
+		}
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf("the reordered bundle doesn't read back the same, nothing was written, these modules changed: %v", changed)
+	}
+
+	logger.Println("Every module of the reordered bundle reads back the same")
+
+	// The file can be the bundle that was read, it's only emptied now
+	if file, ok := w.(*os.File); ok {
+		checkDiskSpace(file.Name(), packed.Len())
+
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+
+		return writeAt(file, packed.Bytes(), 0)
+	}
+
+	_, err = w.Write(packed.Bytes())
+	return err
+}
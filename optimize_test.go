@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAccessOrderSkipsMissingModules(t *testing.T) {
+	modules := map[string][]byte{
+		"startup": []byte("__r(50);__r(0);"),
+		"0":       []byte("__d(function(g,r,i,a,m,e,d){r(d[0])},0,[2,77])"),
+		"1":       []byte("__d(function(g,r,i,a,m,e,d){},1,[])"),
+		"2":       []byte("__d(function(g,r,i,a,m,e,d){},2,[])"),
+	}
+
+	if order := accessOrder(&modules); !slices.Equal(order, []string{"0", "2", "1"}) {
+		t.Errorf("the access order is %v, want [0 2 1]", order)
+	}
+
+	if reachable := reachableModules(&modules); len(reachable) != 2 {
+		t.Errorf("the reachable modules are %v, want 0 and 2", reachable)
+	}
+
+	if count := tableEntryCount(accessOrder(&modules)); count != 3 {
+		t.Errorf("the optimized bundle has %v entries, want 3", count)
+	}
+}
+
+func TestOptimizedOrderLeavesOutHoles(t *testing.T) {
+	defer func(output io.Writer) {
+		logger.SetOutput(output)
+	}(logger.Writer())
+
+	var output bytes.Buffer
+	logger.SetOutput(&output)
+
+	modules := map[string][]byte{
+		"startup": []byte("__r(0);"),
+		"0":       []byte("__d(function(g,r,i,a,m,e,d){r(d[0])},0,[2])"),
+		"1":       {},
+		"2":       []byte("__d(function(g,r,i,a,m,e,d){},2,[])"),
+		"3":       []byte("__d(function(g,r,i,a,m,e,d){},3,[])"),
+	}
+
+	if order := optimizedOrder(&modules); !slices.Equal(order, []string{"0", "2", "3"}) {
+		t.Errorf("the optimized order is %v, want [0 2 3]", order)
+	}
+
+	if !strings.Contains(output.String(), ", 0 modules moved") {
+		t.Errorf("the holes were reported as moved:\n%v", output.String())
+	}
+}
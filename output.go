@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// Where a packed bundle is written, a file or stdout buffered in memory
+type BundleOutput interface {
+	io.WriterAt
+	Truncate(size int64) error
+	Close() error
+}
+
+type memoryOutput struct {
+	data []byte
+}
+
+// Stdout is kept for the bundle when -n is -, everything else printed goes to stderr
+var bundleStdout io.Writer = os.Stdout
+
+// Create the output bundle at path, buffering it in memory when path is - so it can be written to stdout in one pass
+func createOutput(path string) (BundleOutput, error) {
+	if path == "-" {
+		return &memoryOutput{}, nil
+	}
+
+	return createFile(path)
+}
+
+// Pack the modules to the -n file, or to stdout when it's -. An existing file is only replaced with
+// -force or when it's the bundle being read. The file isn't truncated when it's opened
+// since it can be the bundle being read, pack empties it once it has everything it needs
+func writeOutput(modules *map[string][]byte, pack func(*map[string][]byte, io.Writer) error) error {
+	checkOutputFile(outputFilename)
+	logger.Println("Repacking jsbundle.")
+
+	if outputFilename == "-" {
+		// Stdout can't be truncated or written at an offset, hiding the file makes pack build the
+		// bundle in memory
+		if err := pack(modules, struct{ io.Writer }{bundleStdout}); err != nil {
+			return err
+		}
+	} else {
+		file, err := openOutputFile(outputFilename)
+		if err != nil {
+			return err
+		}
+
+		if err := pack(modules, file); err != nil {
+			file.Close()
+			return err
+		}
+
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	logger.Println("jsbundle has been created")
+
+	return nil
+}
+
+// Write a bundle buffered in memory to stdout once it's complete, files are already written
+func flushOutput(output BundleOutput) error {
+	if buffer, ok := output.(*memoryOutput); ok {
+		_, err := bundleStdout.Write(buffer.data)
+		return err
+	}
+
+	return nil
+}
+
+func (o *memoryOutput) WriteAt(data []byte, offset int64) (int, error) {
+	if end := int(offset) + len(data); end > len(o.data) {
+		o.Truncate(int64(end))
+	}
+
+	return copy(o.data[offset:], data), nil
+}
+
+func (o *memoryOutput) Truncate(size int64) error {
+	if int(size) <= len(o.data) {
+		o.data = o.data[:size]
+		return nil
+	}
+
+	o.data = append(o.data, make([]byte, int(size)-len(o.data))...)
+	return nil
+}
+
+func (o *memoryOutput) Close() error {
+	return nil
+}
+
+// Check if file is the one patch mode writes the bundle to
+func isOutputFile(file *os.File) bool {
+	if mode != "patch" || outputFilename == "-" {
+		return false
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	outputInfo, err := os.Stat(outputFilename)
+	return err == nil && os.SameFile(info, outputInfo)
+}
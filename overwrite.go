@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Refuse to unpack into a folder that already has files, stale modules would be packed with the new
+// ones. -force clears it first, archives are overwritten
+func prepareOutputDir() {
+	if dryRun {
+		return
+	}
+
+	if archiveFormat != "" {
+		if _, err := os.Stat(outputDir); err == nil && !force {
+			fail("%v already exists, use -force to overwrite it.\n", outputDir)
+		}
+
+		return
+	}
+
+	files, err := os.ReadDir(outputDir)
+	if err != nil || len(files) == 0 {
+		return
+	}
+
+	if !force {
+		fail("%v isn't empty, use -force to clear it before unpacking.\n", outputDir)
+	}
+
+	// Clearing the folder holding the bundle would delete it before it's read
+	if bundle, err := filepath.Abs(bundlePath); err == nil && bundlePath != "-" {
+		if dir, err := filepath.Abs(outputDir); err == nil && strings.HasPrefix(bundle, dir+string(filepath.Separator)) {
+			fail("%v holds %v, it can't be cleared.\n", outputDir, bundlePath)
+		}
+	}
+
+	for _, file := range files {
+		if err := os.RemoveAll(filepath.Join(outputDir, file.Name())); err != nil {
+			fail("%v\n", err)
+		}
+	}
+}
+
+// Refuse to overwrite an existing output file unless -force is set. Giving the bundle being read as
+// the output asks for it to be written over
+func checkOutputFile(path string) {
+	if dryRun || path == "-" || force {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	if bundle, err := os.Stat(bundlePath); err == nil && os.SameFile(info, bundle) {
+		return
+	}
+
+	fail("%v already exists, use -force to overwrite it.\n", path)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+type PackLayout struct {
+	EntryCount    int           `json:"entryCount"`
+	StartupLength int           `json:"startupLength"`
+	Length        int           `json:"length"`
+	Entries       []PackedEntry `json:"entries"`
+}
+
+type PackedEntry struct {
+	ID     int `json:"id"`
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+// Pack the modules of a folder into a jsbundle file, copying each file into place
+func packFromFolder() error {
+	if bundleFormat == "file" {
+		modules, err := readModulesFromFolder()
+		if err != nil {
+			return err
+		}
+
+		if noStartup {
+			delete(*modules, "startup")
+		}
+
+		return writeFileRAMBundle(modules)
+	}
+
+	if !jsonOutput {
+		logger.Println("Repacking jsbundle.")
+	}
+
+	files, err := listModuleFiles()
+	if err != nil {
+		return err
+	}
+
+	mapComments := map[string]MapComment{}
+	padding := Padding{}
+	var header *ManifestHeader
+	if manifest := readManifest(); manifest != nil {
+		mapComments = manifest.MapComments
+		header = manifest.Header
+
+		if manifest.BigEndian {
+			byteOrder = binary.BigEndian
+		}
+
+		if manifest.Padding != nil {
+			padding = *manifest.Padding
+		}
+	}
+	if noStartup {
+		delete(files, "startup")
+	}
+	ids := []string{}
+	sizes := map[string]int{}
+
+	for id, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+		sizes[id] = int(info.Size()) + len(mapComments[id].Comment)
+	}
+
+	sortModuleIDs(ids)
+
+	if sizes["startup"] == 0 {
+		warn("the bundle has no startup code, the runtime won't require any module by itself.")
+	}
+
+	entryCount := tableEntryCount(ids)
+	moduleStart := jsbundle.ModuleStart(entryCount)
+
+	// Empty files are the holes of the table, unpack writes them for the zero length entries
+	moduleSizes := make([]int, entryCount)
+	for _, id := range ids {
+		if number, err := strconv.Atoi(id); err == nil {
+			moduleSizes[number] = sizes[id]
+		}
+	}
+
+	// The bytes the original bundle had after the startup code are put back before the first module
+	layout := packLayout(padding, header)
+	headerLength, entries, length, err := layout.Place(sizes["startup"], moduleSizes)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return printPackLayout(files, entries, headerLength, length)
+	}
+
+	checkDiskSpace(outputFilename, length)
+
+	outputFile, err := createOutput(outputFilename)
+	if err != nil {
+		return err
+	}
+
+	defer outputFile.Close()
+
+	if err := outputFile.Truncate(int64(length)); err != nil {
+		return err
+	}
+
+	if err := writeAt(outputFile, jsbundle.AppendHeader(nil, byteOrder, headerLength, entries), 0); err != nil {
+		return err
+	}
+
+	// The terminators are already there since the file was truncated to its full length
+	for id, path := range files {
+		start := moduleStart
+		if number, err := strconv.Atoi(id); err == nil {
+			start += entries[number].Offset
+		}
+
+		// Modules with a stripped source map comment have to be loaded to put it back
+		if comment, ok := mapComments[id]; ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			if err := writeAt(outputFile, restoreMapComment(data, comment), int64(start)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err := withRetries(func() error {
+			return copyFileAt(outputFile, path, int64(start))
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeAt(outputFile, padding.AfterStartup, int64(moduleStart+layout.StartupRegion(sizes["startup"], moduleSizes))); err != nil {
+		return err
+	}
+
+	if err := writeAt(outputFile, padding.Trailing, int64(length-len(padding.Trailing))); err != nil {
+		return err
+	}
+
+	if embedProvenance {
+		if err := writeProvenance(outputFile, length); err != nil {
+			return err
+		}
+	}
+
+	if err := flushOutput(outputFile); err != nil {
+		return err
+	}
+
+	logger.Println("jsbundle has been created")
+
+	return nil
+}
+
+// Print the header and entry table pack would write, warning about the holes and empty modules
+func printPackLayout(files map[string]string, entries []jsbundle.Entry, headerLength int, length int) error {
+	layout := PackLayout{EntryCount: len(entries), StartupLength: headerLength, Length: length, Entries: []PackedEntry{}}
+	holes := []int{}
+	empty := []int{}
+
+	for i, entry := range entries {
+		if _, exists := files[strconv.Itoa(i)]; !exists {
+			holes = append(holes, i)
+		} else if entry.Length == 0 {
+			empty = append(empty, i)
+		}
+
+		layout.Entries = append(layout.Entries, PackedEntry{ID: i, Offset: entry.Offset, Length: entry.Length})
+	}
+
+	if len(holes) > 0 {
+		warn("%v entries have no module and would be written as empty: %v", len(holes), holes)
+	}
+
+	if len(empty) > 0 {
+		warn("%v modules are empty files and would be written as empty: %v", len(empty), empty)
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(layout, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Printf("Entries:        %v\n", layout.EntryCount)
+	fmt.Printf("Startup length: %v\n", layout.StartupLength)
+	fmt.Printf("Total size:     %v bytes\n", layout.Length)
+	fmt.Printf("%-8v %-10v %v\n", "ID", "Offset", "Length")
+	for _, entry := range layout.Entries {
+		fmt.Printf("%-8v %-10v %v\n", entry.ID, entry.Offset, entry.Length)
+	}
+
+	fmt.Printf("Would write %v bytes to %v\n", layout.Length, outputFilename)
+
+	return nil
+}
+
+// Copy the content of a file into another file at offset
+func copyFileAt(outputFile io.WriterAt, path string, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(io.NewOffsetWriter(outputFile, offset), f)
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+type Padding struct {
+	AfterStartup []byte `json:"afterStartup,omitempty"`
+	Trailing     []byte `json:"trailing,omitempty"`
+}
+
+// Read the bytes of the bundle that belong to neither the startup code nor a module, returns nil
+// if the bundle is tight
+func readBundlePadding() (*Padding, error) {
+	// Every module of a file RAM bundle is its own file, there's nothing between them
+	if isFileRAMBundle(bundlePath) {
+		return nil, nil
+	}
+
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer bundleFile.Close()
+
+	entries, moduleStart, startupLength, err := readEntryTable(bundleFile)
+	if err != nil {
+		return nil, err
+	}
+
+	firstOffset := -1
+	lastEnd := startupLength
+	for _, entry := range entries {
+		if entry.length == 0 {
+			continue
+		}
+
+		if firstOffset == -1 || entry.offset < firstOffset {
+			firstOffset = entry.offset
+		}
+
+		lastEnd = max(lastEnd, entry.offset+entry.length)
+	}
+
+	padding := Padding{}
+
+	if firstOffset > startupLength {
+		if padding.AfterStartup, err = readFileAtOffset(bundleFile, moduleStart+startupLength, firstOffset-startupLength); err != nil {
+			return nil, err
+		}
+	}
+
+	// A provenance trailer isn't padding, it's written again by -embed-provenance
+	_, trailerLength := readProvenance(bundleFile)
+	size := int(bundleFile.Size()) - trailerLength
+
+	if end := moduleStart + lastEnd; size > end {
+		if padding.Trailing, err = readFileAtOffset(bundleFile, end, size-end); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(padding.AfterStartup) == 0 && len(padding.Trailing) == 0 {
+		return nil, nil
+	}
+
+	return &padding, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// Write a small bundle followed by trailing to a temporary file and return its path
+func writeTestBundle(t *testing.T, bundle *jsbundle.Bundle, trailing []byte) string {
+	t.Helper()
+
+	var content bytes.Buffer
+	if err := bundle.Pack(&content); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "main.jsbundle")
+	if err := os.WriteFile(path, append(content.Bytes(), trailing...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func testBundle() *jsbundle.Bundle {
+	return &jsbundle.Bundle{
+		Startup: []byte("__r(0);"),
+		Modules: [][]byte{
+			[]byte("__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[1])"),
+			[]byte("__d(function(g,r,i,a,m,e,d){m.exports=1},1,[])"),
+		},
+	}
+}
+
+// Get the provenance trailer a bundle of length bytes would end with
+func testProvenanceTrailer(t *testing.T, length int) []byte {
+	t.Helper()
+
+	output := &memoryOutput{data: make([]byte, length)}
+	if err := writeProvenance(output, length); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.data[length:]
+}
+
+func TestReadBundlePadding(t *testing.T) {
+	defer func(path string) { bundlePath = path }(bundlePath)
+
+	var packed bytes.Buffer
+	if err := testBundle().Pack(&packed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		trailing   string
+		provenance bool
+		want       string
+	}{
+		{"tight", "", false, ""},
+		{"padding", "\x00\x00\x00", false, "\x00\x00\x00"},
+		{"provenance", "", true, ""},
+		{"padding and provenance", "\x00\x00\x00", true, "\x00\x00\x00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trailing := []byte(test.trailing)
+			if test.provenance {
+				trailing = append(trailing, testProvenanceTrailer(t, packed.Len()+len(trailing))...)
+			}
+
+			bundlePath = writeTestBundle(t, testBundle(), trailing)
+
+			padding, err := readBundlePadding()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			if padding != nil {
+				got = string(padding.Trailing)
+			}
+
+			if got != test.want {
+				t.Errorf("trailing padding is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRepatchKeepsOneProvenanceTrailer(t *testing.T) {
+	defer func(path, output string, embed bool) {
+		bundlePath, outputFilename, embedProvenance = path, output, embed
+	}(bundlePath, outputFilename, embedProvenance)
+
+	embedProvenance = true
+	bundlePath = writeTestBundle(t, testBundle(), []byte("\x00\x00\x00"))
+
+	for range 2 {
+		modules, err := readModulesFromBundle()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputFilename = filepath.Join(t.TempDir(), "patched.jsbundle")
+		if err := writeOutput(modules, repack); err != nil {
+			t.Fatal(err)
+		}
+
+		bundlePath = outputFilename
+	}
+
+	content, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count := bytes.Count(content, []byte(PROVENANCE_MAGIC)); count != 1 {
+		t.Errorf("the bundle patched twice has %v provenance trailers, want 1", count)
+	}
+
+	padding, err := readBundlePadding()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if padding == nil || string(padding.Trailing) != "\x00\x00\x00" {
+		t.Errorf("the bundle patched twice lost its trailing padding: %+v", padding)
+	}
+}
+
+func TestFolderRoundTripKeepsThePadding(t *testing.T) {
+	modules := []string{
+		"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[1])\x00",
+		"__d(function(g,r,i,a,m,e,d){m.exports=1},1,[])\x00",
+	}
+
+	tests := []struct {
+		name         string
+		afterStartup string
+		trailing     string
+	}{
+		{"after the startup code", "\x00\x00\x00\x00\x00", ""},
+		{"after the last module", "", "\x00\x00\x00"},
+		{"both", "\xcc\xcc\xcc", "\x00\x00\x00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := writeRawBundle(t, "__r(0);\x00", test.afterStartup, modules, test.trailing)
+
+			files, repacked := unpackAndPackFolder(t, original)
+			if string(files["startup"]) != "__r(0);" || string(files["0"]) != strings.TrimSuffix(modules[0], "\x00") {
+				t.Errorf("the padding was unpacked with the code: %q", files)
+			}
+
+			if !bytes.Equal(original, repacked) {
+				t.Errorf("the repacked bundle differs from the original\n%q\n%q", original, repacked)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+type ChangePosition struct {
+	Patch  string `json:"patch"`
+	Module string `json:"module"`
+	jsbundle.Change
+}
+
+var changePositions = []ChangePosition{}
+
+// Keep the positions of the changes a patch made to a module
+func recordChangePositions(name string, patchIndex int, moduleID string, changes []jsbundle.Change) {
+	for _, change := range changes {
+		changePositions = append(changePositions, ChangePosition{Patch: fmt.Sprintf("%v#%v", name, patchIndex), Module: moduleID, Change: change})
+	}
+}
+
+// Write the recorded change positions to -record-positions, a dry run doesn't write anything
+func writeChangePositions() {
+	if recordPositionsPath == "" || dryRun {
+		return
+	}
+
+	content, err := json.MarshalIndent(changePositions, "", "  ")
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	if err := os.WriteFile(recordPositionsPath, append(content, '\n'), 0644); err != nil {
+		fail("%v\n", err)
+	}
+}
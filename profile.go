@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+var patchTimings = map[string]time.Duration{}
+
+// Patches run on several modules at once
+var patchTimingsLock sync.Mutex
+
+// Start the cpu profile if requested and return a function that stops profiling and writes the
+// memory profile. It also runs when the run fails, since os.Exit skips the deferred call
+func startProfiling() func() {
+	var cpuProfile *os.File
+	if cpuProfilePath != "" {
+		var err error
+		if cpuProfile, err = os.Create(cpuProfilePath); err != nil {
+			fail("%v\n", err)
+		}
+
+		if err := pprof.StartCPUProfile(cpuProfile); err != nil {
+			fail("%v\n", err)
+		}
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			stopProfiling(cpuProfile)
+		})
+	}
+
+	onFail(stop)
+
+	return stop
+}
+
+// Flush the cpu profile to its file and write the memory profile
+func stopProfiling(cpuProfile *os.File) {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+
+		if err := cpuProfile.Close(); err != nil {
+			fail("%v\n", err)
+		}
+	}
+
+	if memProfilePath != "" {
+		f, err := os.Create(memProfilePath)
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			fail("%v\n", err)
+		}
+
+		if err := f.Close(); err != nil {
+			fail("%v\n", err)
+		}
+	}
+}
+
+// Add the time spent on a patch entry
+func recordPatchTiming(name string, index int, duration time.Duration) {
+	if !profile {
+		return
+	}
+
+	patchTimingsLock.Lock()
+	defer patchTimingsLock.Unlock()
+
+	patchTimings[fmt.Sprintf("%v#%v", name, index)] += duration
+}
+
+// Print the time spent on each patch entry, slowest first
+func printPatchTimings() {
+	if !profile {
+		return
+	}
+
+	names := []string{}
+	total := time.Duration(0)
+
+	for name, duration := range patchTimings {
+		names = append(names, name)
+		total += duration
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return patchTimings[names[i]] > patchTimings[names[j]]
+	})
+
+	fmt.Fprintln(os.Stderr, "Patch timings:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%12v  %v\n", patchTimings[name].Round(time.Microsecond), name)
+	}
+	fmt.Fprintf(os.Stderr, "%12v  total\n", total.Round(time.Microsecond))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The trailer is the JSON record, its length as a uint32 and this magic, after the module data
+const PROVENANCE_MAGIC = "JSBTPROV"
+
+type Provenance struct {
+	Tool    string   `json:"tool"`
+	Version string   `json:"version"`
+	Time    string   `json:"time"`
+	Patches []string `json:"patches"`
+}
+
+var appliedPatches = []string{}
+
+// Get the time to put in emitted timestamps, pinned by -source-date-epoch for reproducible builds
+func outputTime() time.Time {
+	if sourceDateEpoch >= 0 {
+		return time.Unix(sourceDateEpoch, 0).UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+// Append the provenance trailer to a packed bundle of length bytes
+func writeProvenance(outputFile io.WriterAt, length int) error {
+	provenance := Provenance{
+		Tool:    "jsbundletools",
+		Version: VERSION,
+		Time:    outputTime().Format(time.RFC3339),
+		Patches: appliedPatches,
+	}
+
+	content, err := json.Marshal(provenance)
+	if err != nil {
+		return err
+	}
+
+	trailer := binary.LittleEndian.AppendUint32(content, uint32(len(content)))
+	trailer = append(trailer, PROVENANCE_MAGIC...)
+
+	return writeAt(outputFile, trailer, int64(length))
+}
+
+// Read the provenance trailer of a bundle and its length, returns nil if there's none
+func readProvenance(bundleFile BundleSource) (*Provenance, int) {
+	size := int(bundleFile.Size())
+	footerLength := UINT32_LENGTH + len(PROVENANCE_MAGIC)
+	if size < footerLength {
+		return nil, 0
+	}
+
+	footer, err := readFileAtOffset(bundleFile, size-footerLength, footerLength)
+	if err != nil || string(footer[UINT32_LENGTH:]) != PROVENANCE_MAGIC {
+		return nil, 0
+	}
+
+	length := int(binary.LittleEndian.Uint32(footer))
+	if length > size-footerLength {
+		return nil, 0
+	}
+
+	content, err := readFileAtOffset(bundleFile, size-footerLength-length, length)
+	if err != nil {
+		return nil, 0
+	}
+
+	var provenance Provenance
+	if err := json.Unmarshal(content, &provenance); err != nil {
+		return nil, 0
+	}
+
+	return &provenance, footerLength + length
+}
+
+type BundleInfo struct {
+	Magic            string      `json:"magic"`
+	EntryCount       int         `json:"entryCount"`
+	StartupLength    int         `json:"startupLength"`
+	FileSize         int         `json:"fileSize"`
+	ModuleRegionSize int         `json:"moduleRegionSize"`
+	ExpectedSize     int         `json:"expectedSize"`
+	ByteOrder        string      `json:"byteOrder"`
+	Provenance       *Provenance `json:"provenance,omitempty"`
+}
+
+// Print the header of the bundle, the sizes of its regions and its provenance if it has one,
+// reading the entry table but not the modules
+func printInfo() {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	header, err := readHeader(bundleFile)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	entries, moduleStart, _, err := readEntryTable(bundleFile)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	provenance, trailerLength := readProvenance(bundleFile)
+
+	info := BundleInfo{
+		Magic:         fmt.Sprintf("0x%08x", header.Magic),
+		EntryCount:    header.EntryCount,
+		StartupLength: header.StartupLength,
+		FileSize:      int(bundleFile.Size()),
+		ByteOrder:     byteOrderName(header.ByteOrder),
+		Provenance:    provenance,
+	}
+
+	for _, entry := range entries {
+		info.ModuleRegionSize += entry.length
+	}
+
+	info.ExpectedSize = moduleStart + info.StartupLength + info.ModuleRegionSize + trailerLength
+
+	if info.FileSize < info.ExpectedSize {
+		warn("the table, the startup code and the modules add up to %v bytes but the file only has %v, the bundle is likely truncated.", info.ExpectedSize, info.FileSize)
+	} else if info.FileSize > info.ExpectedSize {
+		warn("the file has %v bytes outside of the table, the startup code and the modules, from padding, alignment or a malformed table.", info.FileSize-info.ExpectedSize)
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		fmt.Println(string(output))
+		return
+	}
+
+	fmt.Printf("Magic:          %v (%v)\n", info.Magic, info.ByteOrder)
+	fmt.Printf("Entries:        %v\n", info.EntryCount)
+	fmt.Printf("Startup length: %v\n", info.StartupLength)
+	fmt.Printf("File size:      %v\n", info.FileSize)
+	fmt.Printf("Module region:  %v\n", info.ModuleRegionSize)
+
+	if provenance == nil {
+		return
+	}
+
+	fmt.Printf("Packed by:      %v %v at %v\n", provenance.Tool, provenance.Version, provenance.Time)
+	fmt.Println("Patches:")
+	for _, name := range provenance.Patches {
+		fmt.Println("   ", name)
+	}
+}
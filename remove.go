@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Remove the modules a patch file lists by id or by a text they contain. The removed modules become
+// empty entries so every other id keeps its place in the table, and the modules still depending on
+// them are warned about since requiring them fails at runtime
+func removeModules(modules *map[string][]byte, info PatchInfo) {
+	removed := []string{}
+
+	for _, moduleID := range info.RemoveModules {
+		if moduleID == "startup" {
+			fail("The startup code can't be removed by %v.\n", info.Name)
+		}
+
+		if len((*modules)[moduleID]) == 0 {
+			fail("Module %v removed by %v doesn't exist.\n", moduleID, info.Name)
+		}
+
+		removed = append(removed, moduleID)
+	}
+
+	for _, find := range info.RemoveModulesFind {
+		found := false
+
+		for _, moduleID := range sortedModuleIDs(modules) {
+			if moduleID != "startup" && strings.Contains(string((*modules)[moduleID]), find) {
+				removed = append(removed, moduleID)
+				found = true
+			}
+		}
+
+		if !found {
+			warn("no module contains %q, removed by %v", find, info.Name)
+		}
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+
+	for _, moduleID := range removed {
+		if len((*modules)[moduleID]) > 0 {
+			(*modules)[moduleID] = []byte{}
+			logger.Printf("Removed module %v for %v\n", moduleID, info.Name)
+		}
+	}
+
+	for _, id := range entryModules(modules) {
+		if slices.Contains(removed, strconv.Itoa(id)) {
+			warn("module %v removed by %v is required by the startup code", id, info.Name)
+		}
+	}
+
+	dependencies := moduleDependencies(modules)
+	for _, moduleID := range sortedModuleIDs(modules) {
+		for _, dep := range dependencies[moduleID] {
+			if slices.Contains(removed, strconv.Itoa(dep)) {
+				warn("module %v depends on module %v, removed by %v", moduleID, dep, info.Name)
+			}
+		}
+	}
+}
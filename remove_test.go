@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+func TestRemovedModulesKeepTheOtherIDs(t *testing.T) {
+	modules := map[string][]byte{
+		"startup": []byte("__r(0);"),
+		"0":       []byte("__d(function(g,r,i,a,m,e,d){r(d[0]);r(d[1])},0,[1,3])"),
+		"1":       []byte("__d(function(g,r,i,a,m,e,d){m.exports=1},1,[])"),
+		"2":       []byte("__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])"),
+		"3":       []byte("__d(function(g,r,i,a,m,e,d){m.exports=3},3,[])"),
+		"4":       []byte("__d(function(g,r,i,a,m,e,d){/* debug only */},4,[])"),
+	}
+
+	original := map[string][]byte{}
+	for id, module := range modules {
+		original[id] = module
+	}
+
+	removeModules(&modules, PatchInfo{Name: "test", RemoveModules: []string{"2"}, RemoveModulesFind: []string{"debug only"}})
+
+	var packed bytes.Buffer
+	if err := pack(&modules, &packed); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := jsbundle.UnpackBytes(packed.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bundle.Modules) != 5 || bundle.Modules[2] != nil || bundle.Modules[4] != nil {
+		t.Fatalf("the removed modules aren't empty entries of the 5 entry table: %q", bundle.Modules)
+	}
+
+	for _, id := range []int{0, 1, 3} {
+		if !bytes.Equal(bundle.Modules[id], original[strconv.Itoa(id)]) {
+			t.Errorf("module %v is %q after the removal, want %q", id, bundle.Modules[id], original[strconv.Itoa(id)])
+		}
+	}
+
+	// The dependencies of module 0 still point at the modules they did
+	_, deps, ok := parseModuleFooter(bundle.Modules[0])
+	if !ok || !slices.Equal(deps, []int{1, 3}) {
+		t.Fatalf("module 0 depends on %v, want [1 3]", deps)
+	}
+
+	for _, dep := range deps {
+		if depID, _, ok := parseModuleFooter(bundle.Modules[dep]); !ok || depID != dep {
+			t.Errorf("dependency %v resolves to %q", dep, bundle.Modules[dep])
+		}
+	}
+}
+
+func TestCompileKeepsRemovals(t *testing.T) {
+	info := PatchInfo{Name: "test", RemoveModules: []string{"2"}, RemoveModulesFind: []string{"debug only"}}
+
+	compiled := compilePatches(info)
+	if !slices.Equal(compiled.RemoveModules, info.RemoveModules) || !slices.Equal(compiled.RemoveModulesFind, info.RemoveModulesFind) {
+		t.Errorf("the compiled patch file removes %v and %v, want %v and %v", compiled.RemoveModules, compiled.RemoveModulesFind, info.RemoveModules, info.RemoveModulesFind)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var identifierRegex = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// Check if a byte can be part of a JavaScript identifier
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// Check if the identifier starting at position is a property access like x.from
+func isPropertyAccess(content []byte, position int) bool {
+	for position--; position >= 0; position-- {
+		switch content[position] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '.':
+			return true
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// Rename every occurrence of the identifier from to to, leaving strings and comments alone
+func renameIdentifier(content []byte, from string, to string, props bool) ([]byte, int) {
+	renamed := []byte{}
+	count := 0
+
+	position := 0
+	for position < len(content) {
+		switch content[position] {
+		case '"', '\'', '`', '/':
+			end := skipLiteral(content, position)
+			renamed = append(renamed, content[position:end]...)
+			position = end
+			continue
+		}
+
+		if !isIdentifierByte(content[position]) {
+			renamed = append(renamed, content[position])
+			position++
+			continue
+		}
+
+		end := position
+		for end < len(content) && isIdentifierByte(content[end]) {
+			end++
+		}
+
+		if string(content[position:end]) == from && (props || !isPropertyAccess(content, position)) {
+			renamed = append(renamed, to...)
+			count++
+		} else {
+			renamed = append(renamed, content[position:end]...)
+		}
+
+		position = end
+	}
+
+	return renamed, count
+}
+
+// Rename an identifier in the module set with -module, then print it
+func renameInModule(modules *map[string][]byte) {
+	content, ok := (*modules)[selectedModule]
+	if !ok {
+		fail("Module %v doesn't exist.\n", selectedModule)
+	}
+
+	if !identifierRegex.MatchString(renameFrom) || !identifierRegex.MatchString(renameTo) {
+		fail("The names to rename from and to must be identifiers.\n")
+	}
+
+	renamed, count := renameIdentifier(content, renameFrom, renameTo, includeProps)
+	(*modules)[selectedModule] = renamed
+
+	if renamePack {
+		logger.Printf("Renamed %v occurrences of %v in module %v\n", count, renameFrom, selectedModule)
+		return
+	}
+
+	fmt.Println(string(renamed))
+}
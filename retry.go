@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Check if an error is worth retrying, like the ones network filesystems return
+func isTransientError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// Run fn until it succeeds, fails with a permanent error or runs out of retries
+func withRetries(fn func() error) error {
+	delay := 50 * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isTransientError(err) {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Create a file, retrying on transient errors
+func createFile(path string) (*os.File, error) {
+	var file *os.File
+
+	err := withRetries(func() error {
+		var err error
+		file, err = os.Create(path)
+		return err
+	})
+
+	return file, err
+}
+
+// Open a file for writing without truncating it, creating it if needed, retrying on transient errors
+func openOutputFile(path string) (*os.File, error) {
+	var file *os.File
+
+	err := withRetries(func() error {
+		var err error
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+		return err
+	})
+
+	return file, err
+}
+
+// Write data to a file at offset, retrying on transient errors
+func writeAt(file io.WriterAt, data []byte, offset int64) error {
+	return withRetries(func() error {
+		_, err := file.WriteAt(data, offset)
+		return err
+	})
+}
+
+// A writer retrying its writes on transient errors
+type retryWriter struct {
+	io.WriterAt
+}
+
+func (w retryWriter) WriteAt(data []byte, offset int64) (int, error) {
+	return len(data), writeAt(w.WriterAt, data, offset)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The bytes shown before and after the first difference
+const ROUNDTRIP_CONTEXT = 8
+
+// Unpack the bundle into memory, pack it into a buffer and compare it with the original byte for byte
+func verifyRoundtrip() {
+	original, err := readBundleContent(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	modules, err := readModulesFromBundle()
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	repacked := &bytes.Buffer{}
+	if err := repack(modules, repacked); err != nil {
+		fail("%v\n", err)
+	}
+
+	offset := 0
+	for offset < len(original) && offset < repacked.Len() && original[offset] == repacked.Bytes()[offset] {
+		offset++
+	}
+
+	if offset == len(original) && offset == repacked.Len() {
+		fmt.Printf("Roundtrip passed, the %v bytes of %v were packed back the same\n", len(original), bundlePath)
+		return
+	}
+
+	fmt.Printf("Roundtrip failed at offset 0x%x, in the %v\n", offset, describeBundleOffset(offset))
+	if len(original) != repacked.Len() {
+		fmt.Printf("The original is %v bytes, the repacked bundle %v bytes\n", len(original), repacked.Len())
+	}
+
+	fmt.Printf("    original  %v\n", hexContext(original, offset))
+	fmt.Printf("    repacked  %v\n", hexContext(repacked.Bytes(), offset))
+
+	exitFailed()
+}
+
+// Read the whole bundle, stdin included
+func readBundleContent(path string) ([]byte, error) {
+	bundleFile, err := openBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer bundleFile.Close()
+
+	return readFileAtOffset(bundleFile, 0, int(bundleFile.Size()))
+}
+
+// Name the header field, the startup code or the module of the original bundle an offset falls in
+func describeBundleOffset(offset int) string {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		return "bundle"
+	}
+
+	defer bundleFile.Close()
+
+	entries, moduleStart, startupLength, err := readEntryTable(bundleFile)
+	if err != nil {
+		return "bundle"
+	}
+
+	if offset < moduleStart {
+		return headerFieldName(offset-offset%UINT32_LENGTH, moduleStart)
+	}
+
+	if offset < moduleStart+startupLength {
+		return "startup code"
+	}
+
+	for index, entry := range entries {
+		if entry.length > 0 && offset >= moduleStart+entry.offset && offset < moduleStart+entry.offset+entry.length {
+			return "module " + strconv.Itoa(index)
+		}
+	}
+
+	return "bytes outside of every module"
+}
+
+// Format the bytes around offset as hex, with the one at offset in brackets
+func hexContext(data []byte, offset int) string {
+	parts := []string{}
+
+	for index := max(offset-ROUNDTRIP_CONTEXT, 0); index < min(offset+ROUNDTRIP_CONTEXT, len(data)); index++ {
+		if index == offset {
+			parts = append(parts, fmt.Sprintf("[%02x]", data[index]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%02x", data[index]))
+		}
+	}
+
+	if offset >= len(data) {
+		parts = append(parts, "[end]")
+	}
+
+	return strings.Join(parts, " ")
+}
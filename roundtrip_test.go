@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Write a bundle with the module data in the order of layout while the table stays in id order
+func writeBundleInOrder(t *testing.T, startup string, modules []string, layout []int) string {
+	t.Helper()
+
+	offsets := make([]int, len(modules))
+	data := append([]byte(startup), 0)
+	for _, id := range layout {
+		offsets[id] = len(data)
+		data = append(append(data, modules[id]...), 0)
+	}
+
+	content := binary.LittleEndian.AppendUint32(nil, 0xfb0bd1e5)
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(modules)))
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(startup)+1))
+	for id, module := range modules {
+		content = binary.LittleEndian.AppendUint32(content, uint32(offsets[id]))
+		content = binary.LittleEndian.AppendUint32(content, uint32(len(module)+1))
+	}
+
+	path := filepath.Join(t.TempDir(), "main.jsbundle")
+	if err := os.WriteFile(path, append(content, data...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRepackKeepsTheLayout(t *testing.T) {
+	defer func(path string) { bundlePath = path }(bundlePath)
+
+	modules := []string{
+		"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[1])",
+		"__d(function(g,r,i,a,m,e,d){m.exports=1},1,[])",
+		"__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])",
+	}
+
+	tests := []struct {
+		name   string
+		layout []int
+	}{
+		{"id order", []int{0, 1, 2}},
+		{"reversed", []int{2, 1, 0}},
+		{"entry module last", []int{1, 2, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundlePath = writeBundleInOrder(t, "__r(0);", modules, test.layout)
+
+			original, err := os.ReadFile(bundlePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			read, err := readModulesFromBundle()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			repacked := &bytes.Buffer{}
+			if err := repack(read, repacked); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(original, repacked.Bytes()) {
+				t.Errorf("repacked bundle differs from the original\n%x\n%x", original, repacked.Bytes())
+			}
+		})
+	}
+}
+
+// Write a bundle of raw regions, the header lengths are the lengths of the regions as given so a
+// region without a NUL isn't terminated. Empty modules are holes of the table
+func writeRawBundle(t *testing.T, startup string, afterStartup string, modules []string, trailing string) []byte {
+	t.Helper()
+
+	content := binary.LittleEndian.AppendUint32(nil, 0xfb0bd1e5)
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(modules)))
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(startup)))
+
+	data := startup + afterStartup
+	for _, module := range modules {
+		offset := len(data)
+		if module == "" {
+			offset = 0
+		}
+
+		content = binary.LittleEndian.AppendUint32(content, uint32(offset))
+		content = binary.LittleEndian.AppendUint32(content, uint32(len(module)))
+		data += module
+	}
+
+	return append(content, data+trailing...)
+}
+
+// Unpack a bundle to a folder and pack the folder again, returning the unpacked files and the packed bundle
+func unpackAndPackFolder(t *testing.T, original []byte) (map[string][]byte, []byte) {
+	t.Helper()
+
+	defer func(previousMode string, bundle string, output string, filename string) {
+		mode, bundlePath, outputDir, outputFilename = previousMode, bundle, output, filename
+	}(mode, bundlePath, outputDir, outputFilename)
+
+	mode = "unpack"
+	dir := t.TempDir()
+	bundlePath = filepath.Join(dir, "main.jsbundle")
+	outputDir = filepath.Join(dir, "modules")
+	outputFilename = filepath.Join(dir, "repacked.jsbundle")
+
+	if err := os.WriteFile(bundlePath, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	modules, err := readModulesFromBundle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unpack(modules); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for _, id := range []string{"startup", "0", "1", "2"} {
+		if data, err := os.ReadFile(filepath.Join(outputDir, id+".js")); err == nil {
+			files[id] = data
+		}
+	}
+
+	mode = "pack"
+	if err := packFromFolder(); err != nil {
+		t.Fatal(err)
+	}
+
+	repacked, err := os.ReadFile(outputFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files, repacked
+}
+
+func TestFolderRoundTripKeepsTheStartupCode(t *testing.T) {
+	modules := []string{
+		"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[1])\x00",
+		"__d(function(g,r,i,a,m,e,d){m.exports=1},1,[])\x00",
+	}
+
+	tests := []struct {
+		name    string
+		startup string
+		want    string
+	}{
+		{"terminated", "__r(0);\x00", "__r(0);"},
+		{"unterminated", "__r(0);", "__r(0);"},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := writeRawBundle(t, test.startup, "", modules, "")
+
+			files, repacked := unpackAndPackFolder(t, original)
+			if string(files["startup"]) != test.want {
+				t.Errorf("the startup code was unpacked as %q, want %q", files["startup"], test.want)
+			}
+
+			if !bytes.Equal(original, repacked) {
+				t.Errorf("the repacked bundle differs from the original\n%q\n%q", original, repacked)
+			}
+		})
+	}
+}
+
+func TestFolderRoundTripKeepsTheModules(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules []string
+		want    []string
+	}{
+		{
+			"terminated",
+			[]string{"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[2])\x00", "", "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])\x00"},
+			[]string{"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[2])", "", "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])"},
+		},
+		{
+			"unterminated",
+			[]string{"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[2]);", "", "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])"},
+			[]string{"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[2]);", "", "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])"},
+		},
+		{
+			"a NUL inside",
+			[]string{"__d(function(g,r,i,a,m,e,d){m.exports=\"\x00\"},0,[])\x00", "", "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])\x00\x00"},
+			[]string{"__d(function(g,r,i,a,m,e,d){m.exports=\"\x00\"},0,[])", "", "__d(function(g,r,i,a,m,e,d){m.exports=2},2,[])\x00"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := writeRawBundle(t, "__r(0);\x00", "", test.modules, "")
+
+			files, repacked := unpackAndPackFolder(t, original)
+			for id, want := range test.want {
+				if got := string(files[strconv.Itoa(id)]); got != want {
+					t.Errorf("module %v was unpacked as %q, want %q", id, got, want)
+				}
+			}
+
+			if !bytes.Equal(original, repacked) {
+				t.Errorf("the repacked bundle differs from the original\n%q\n%q", original, repacked)
+			}
+		})
+	}
+}
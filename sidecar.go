@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Read the lines of a sidecar file
+func readSidecarLines(sidecarPath string, patchFileName string) []string {
+	jsContent, err := os.ReadFile(sidecarPath)
+	if err != nil {
+		fail("Sidecar %v referenced by %v could not be read: %v\n", sidecarPath, patchFileName, err)
+	}
+
+	// Sidecars saved on Windows would leave a \r at the end of every line
+	content := strings.ReplaceAll(string(jsContent), "\r\n", "\n")
+	lines := strings.Split(content, "\n")
+
+	// A trailing newline isn't an extra line
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Get a line from a sidecar file
+func sidecarLine(lines []string, sidecarPath string, index int) string {
+	if index < 0 || index >= len(lines) {
+		fail("Line %v is out of range in sidecar %v (%v lines).\n", index, sidecarPath, len(lines))
+	}
+
+	if lines[index] == "" && !allowEmptyLine {
+		fail("Line %v of sidecar %v is empty, use -allow-empty-line if this is intended.\n", index, sidecarPath)
+	}
+
+	return lines[index]
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCRLFSidecarInjection(t *testing.T) {
+	defer func(path string) { patchesDir = path }(patchesDir)
+	patchesDir = t.TempDir()
+
+	files := map[string]string{
+		"exports.json": `{"patches":[{"find":"m.exports=1","FReplace":1},{"find":"m.exports=2","Fappend":2}]}`,
+		"exports.js":   "// replacements\r\nm.exports=one\r\n;m.extra=2\r\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(patchesDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modules := map[string][]byte{
+		"startup": []byte("__r(0);"),
+		"0":       []byte("__d(function(g,r,i,a,m,e,d){m.exports=1},0,[])"),
+		"1":       []byte("__d(function(g,r,i,a,m,e,d){m.exports=2},1,[])"),
+	}
+
+	patch(&modules)
+
+	want := map[string]string{
+		"0": "__d(function(g,r,i,a,m,e,d){m.exports=one},0,[])",
+		"1": "__d(function(g,r,i,a,m,e,d){m.exports=2;m.extra=2},1,[])",
+	}
+
+	for id, module := range want {
+		if string(modules[id]) != module {
+			t.Errorf("module %v is %q, want %q", id, modules[id], module)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Where a bundle is read from, a file or stdin buffered in memory
+type BundleSource interface {
+	io.ReaderAt
+	Name() string
+	Size() int64
+	Close() error
+}
+
+type fileSource struct {
+	*os.File
+	size int64
+}
+
+type memorySource struct {
+	*bytes.Reader
+	name string
+}
+
+// Stdin can only be read once, so it's kept for the modes that open the bundle more than once
+var stdinContent []byte
+
+// Open the bundle at path, reading it from stdin when path is -
+func openBundle(path string) (BundleSource, error) {
+	if path == "-" {
+		if stdinContent == nil {
+			content, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+
+			stdinContent = content
+		}
+
+		return &memorySource{Reader: bytes.NewReader(stdinContent), name: "stdin"}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileSource{File: file, size: info.Size()}, nil
+}
+
+func (s *fileSource) Size() int64 {
+	return s.size
+}
+
+func (s *memorySource) Name() string {
+	return s.name
+}
+
+func (s *memorySource) Close() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+var inlineMapRegex = regexp.MustCompile(`//# sourceMappingURL=data:application/json(?:;charset=[\w-]+)?;base64,([A-Za-z0-9+/=]+)`)
+
+type SourceMap struct {
+	ModulePaths []string `json:"x_metro_module_paths"`
+}
+
+// Read the source map and return a map of module ids to source paths
+func readModulePaths() map[string]string {
+	if sourceMapPath == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(sourceMapPath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	var sourceMap SourceMap
+	if err := json.Unmarshal(content, &sourceMap); err != nil {
+		fail("Could not parse the source map %v: %v\n", sourceMapPath, err)
+	}
+
+	paths := map[string]string{}
+
+	for id, path := range sourceMap.ModulePaths {
+		if path != "" {
+			paths[strconv.Itoa(id)] = path
+		}
+	}
+
+	return paths
+}
+
+// Decode the inline source map at the end of a module body, returns the map, the comment holding
+// it and the number of bytes after the comment
+func extractInlineMap(content []byte) ([]byte, []byte, int, bool) {
+	locations := inlineMapRegex.FindAllSubmatchIndex(content, -1)
+	if locations == nil {
+		return nil, nil, 0, false
+	}
+
+	location := locations[len(locations)-1]
+
+	// The comment has to be the end of the module or the end of its factory
+	rest := bytes.TrimLeft(content[location[1]:], " \t\r\n")
+	if len(rest) > 0 {
+		if rest[0] != '}' {
+			return nil, nil, 0, false
+		}
+
+		footer := moduleFooterRegex.FindIndex(rest[1:])
+		if footer == nil || footer[0] != 0 {
+			return nil, nil, 0, false
+		}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(content[location[2]:location[3]]))
+	if err != nil {
+		return nil, nil, 0, false
+	}
+
+	return decoded, content[location[0]:location[1]], len(content) - location[1], true
+}
+
+// Put a stripped inline source map comment back into a module
+func restoreMapComment(content []byte, comment MapComment) []byte {
+	if comment.Suffix > len(content) {
+		return append(content, comment.Comment...)
+	}
+
+	end := len(content) - comment.Suffix
+
+	restored := append([]byte{}, content[:end]...)
+	restored = append(restored, comment.Comment...)
+	return append(restored, content[end:]...)
+}
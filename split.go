@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+)
+
+// Read the modules from a plain bundle by looking for the __d calls
+func readModulesFromPlainBundle() *map[string][]byte {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	content, err := readFileAtOffset(bundleFile, 0, int(bundleFile.Size()))
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	modules := map[string][]byte{}
+	startup := []byte{}
+	maxID := -1
+
+	position := 0
+	segmentStart := 0
+
+	for position < len(content) {
+		switch content[position] {
+		case '"', '\'', '`', '/':
+			position = skipLiteral(content, position)
+			continue
+		}
+
+		if string(content[position:min(position+4, len(content))]) != "__d(" {
+			position++
+			continue
+		}
+
+		end := findCallEnd(content, position+3)
+		if end == -1 {
+			fail("Unterminated module at offset %v.\n", position)
+		}
+
+		if end < len(content) && content[end] == ';' {
+			end++
+		}
+
+		module := content[position:end]
+
+		id, _, ok := parseModuleFooter(module)
+		if !ok {
+			fail("Could not parse the module id at offset %v.\n", position)
+		}
+
+		if _, exists := modules[strconv.Itoa(id)]; exists {
+			fail("Module %v is defined more than once.\n", id)
+		}
+
+		startup = append(startup, content[segmentStart:position]...)
+		modules[strconv.Itoa(id)] = module
+		maxID = max(maxID, id)
+
+		if end < len(content) && content[end] == '\n' {
+			end++
+		}
+
+		position = end
+		segmentStart = end
+	}
+
+	startup = append(startup, content[segmentStart:]...)
+
+	// Fill the ids that aren't used by any module
+	for id := 0; id <= maxID; id++ {
+		if _, ok := modules[strconv.Itoa(id)]; !ok {
+			modules[strconv.Itoa(id)] = []byte{}
+		}
+	}
+
+	modules["startup"] = startup
+
+	return &modules
+}
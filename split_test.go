@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+func TestSplitIgnoresDecoyWrappers(t *testing.T) {
+	defer func(path string) { bundlePath = path }(bundlePath)
+
+	decoy := `__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[1])`
+	modules := []string{
+		`__d(function(g,r,i,a,m,e,d){m.exports={asset:"` + decoy + `",next:r(d[0])}},0,[1]);`,
+		`__d(function(g,r,i,a,m,e,d){m.exports='__d(function(){},99,[])'},1,[]);`,
+	}
+
+	bundlePath = filepath.Join(t.TempDir(), "main.js")
+	if err := os.WriteFile(bundlePath, []byte("var __DEV__=false;\n"+modules[0]+"\n"+modules[1]+"\n__r(0);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read := *readModulesFromPlainBundle()
+	if len(read) != 3 || string(read["0"]) != modules[0] || string(read["1"]) != modules[1] {
+		t.Fatalf("the bundle was split into %q", read)
+	}
+
+	if string(read["startup"]) != "var __DEV__=false;\n__r(0);" {
+		t.Errorf("the startup code is %q", read["startup"])
+	}
+
+	// The import goes into the real wrapper, the decoy in the string stays as it was
+	patched, ok := jsbundle.InjectImport(read["0"], "2", "cmod1")
+	want := `__d(function(g,r,i,a,m,e,d){var cmod1=r(d[1]);m.exports={asset:"` + decoy + `",next:r(d[0])}},0,[1,2]);`
+	if !ok || string(patched) != want {
+		t.Errorf("the import was injected as %q, want %q", patched, want)
+	}
+
+	// A module without a wrapper of its own can't be patched through the one in its string
+	if _, ok := jsbundle.InjectImport([]byte(`m.exports="`+decoy+`"`), "2", "cmod1"); ok {
+		t.Error("the import was injected into the decoy of an unwrapped module")
+	}
+
+	if id, deps, ok := parseModuleFooter(patched); !ok || id != 0 || len(deps) != 2 {
+		t.Errorf("the footer of the patched module is %v %v", id, deps)
+	}
+}
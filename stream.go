@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// Check if unpack can write each module as soon as it's read. Other names and layouts, ES modules,
+// source maps and size filters need to see every module first, and -mmap reads them from the mapping
+func canStreamUnpack() bool {
+	return !isFileRAMBundle(bundlePath) && naming == "id" && layout == "flat" && !esm && !extractMaps && !sizeFilter && !dryRun && !useMmap
+}
+
+// Unpack the bundle like unpack, reading and writing one module at a time instead of reading them all first
+func unpackStream() error {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	defer bundleFile.Close()
+
+	entries, moduleStart, startupLength, err := readEntryTable(bundleFile)
+	if err != nil {
+		return err
+	}
+
+	if byteOrder, err = readBundleByteOrder(bundlePath); err != nil {
+		return err
+	}
+
+	// The NUL terminators are warned about when the files are written
+	readModule := func(id int) ([]byte, error) {
+		module, err := readFileAtOffset(bundleFile, moduleStart+entries[id].offset, entries[id].length)
+		if err != nil {
+			return nil, fmt.Errorf("module %v: %w", id, err)
+		}
+
+		return bytes.TrimSuffix(module, []byte{0}), nil
+	}
+
+	// See jsbundle.StartupRegionLength for how the startup code is stored
+	startup, err := readFileAtOffset(bundleFile, moduleStart, startupLength)
+	if err != nil {
+		return fmt.Errorf("the startup code: %w", err)
+	}
+
+	if err := checkStartupBoundary(entries, moduleStart, startupLength, bytes.TrimSuffix(startup, []byte{0}), readModule); err != nil {
+		return err
+	}
+
+	if !jsonOutput {
+		logger.Println("Unpacking", bundlePath)
+	}
+
+	writer, err := newUnpackWriter()
+	if err != nil {
+		return err
+	}
+
+	defer writer.Abort()
+
+	lengths := map[string]int{"startup": startupLength}
+	for index, entry := range entries {
+		lengths[strconv.Itoa(index)] = entry.length
+	}
+
+	stream := &streamWriter{writer: writer, lengths: lengths, names: map[string]string{}}
+	if err := jsbundle.UnpackStreamTo(bundleFile, stream); err != nil {
+		return err
+	}
+
+	names, files := stream.names, stream.files
+
+	padding, err := readBundlePadding()
+	if err != nil {
+		return err
+	}
+
+	header, err := readManifestHeader()
+	if err != nil {
+		return err
+	}
+
+	if err := writeManifest(writer, Manifest{Naming: naming, Layout: layout, Files: names, MapComments: map[string]MapComment{}, Padding: padding, BigEndian: byteOrder == binary.BigEndian, Header: header}); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Path < files[j].Path
+		})
+
+		output, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(output))
+		return nil
+	}
+
+	logger.Println("Done!")
+
+	return nil
+}
+
+// Write the files of jsbundle.UnpackStreamTo to the unpack writer, listing them for the manifest and
+// -json. lengths has the table length of every id to tell which ones weren't NUL terminated
+type streamWriter struct {
+	writer  UnpackWriter
+	lengths map[string]int
+	names   map[string]string
+	files   []UnpackedFile
+}
+
+func (w *streamWriter) WriteFile(name string, content []byte) error {
+	id := strings.TrimSuffix(name, ".js")
+	if id == "startup" {
+		checkTerminator("the startup code", w.lengths[id], content)
+	} else {
+		checkTerminator("module "+id, w.lengths[id], content)
+	}
+
+	w.names[id] = name
+	w.files = append(w.files, UnpackedFile{ID: id, Path: filepath.Join(outputDir, name), Size: len(content)})
+
+	return w.writer.WriteFile(name, content)
+}
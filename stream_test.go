@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamedUnpackLeavesNoPartialOutput(t *testing.T) {
+	defer func(previousMode string, bundle string, output string) {
+		mode, bundlePath, outputDir = previousMode, bundle, output
+	}(mode, bundlePath, outputDir)
+
+	modules := []string{
+		"__d(function(g,r,i,a,m,e,d){m.exports=r(d[0])},0,[1])\x00",
+		"__d(function(g,r,i,a,m,e,d){m.exports=1},1,[])\x00",
+	}
+
+	original := writeRawBundle(t, "__r(0);\x00", "", modules, "")
+
+	dir := t.TempDir()
+	mode = "unpack"
+	bundlePath = filepath.Join(dir, "main.jsbundle")
+	outputDir = filepath.Join(dir, "modules")
+
+	if err := os.WriteFile(bundlePath, original[:len(original)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !canStreamUnpack() {
+		t.Fatal("the bundle isn't unpacked as a stream")
+	}
+
+	if err := unpackStream(); err == nil {
+		t.Fatal("a truncated bundle was unpacked")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("the failed unpack left files next to the bundle: %v", files)
+	}
+
+	// The folder is only there once every module was written
+	if err := os.WriteFile(bundlePath, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unpackStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "1.js")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Replace the modules matching the strip paths with empty modules
+func stripModules(modules *map[string][]byte) {
+	names := strings.Split(stripPaths, ",")
+	modulePaths := readModulePaths()
+	reachable := reachableModules(modules)
+
+	stripped := 0
+	saved := 0
+
+	for _, moduleID := range sortedModuleIDs(modules) {
+		content := (*modules)[moduleID]
+		if moduleID == "startup" || len(content) == 0 {
+			continue
+		}
+
+		path, ok := modulePaths[moduleID]
+		if !ok {
+			path = moduleVerboseName(content)
+		}
+
+		// Without strip paths every module within the size limits is stripped
+		matched := stripPaths == ""
+		for _, name := range names {
+			if name != "" && strings.Contains(path, name) {
+				matched = true
+			}
+		}
+
+		if sizeFilter && !moduleSizeMatches(content) {
+			matched = false
+		}
+
+		if !matched {
+			continue
+		}
+
+		if reachable[moduleID] && !stripReachable {
+			fail("Module %v (%v) can be required from the startup code, use -strip-reachable to strip it anyway.\n", moduleID, path)
+		}
+
+		// Keep an empty module so the ids other modules depend on still resolve
+		stub := []byte(fmt.Sprintf("__d(function(g,r,i,a,m,e,d){},%v,[])", moduleID))
+
+		saved += len(content) - len(stub)
+		stripped++
+		(*modules)[moduleID] = stub
+
+		logger.Printf("Stripped module %v (%v)\n", moduleID, path)
+	}
+
+	logger.Printf("Stripped %v modules, saved %v bytes\n", stripped, saved)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Print the entry table of the bundle, one row per entry
+func printEntryTable() {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	entries, moduleStart, _, err := readEntryTable(bundleFile)
+	if err != nil {
+		fail("%v\n", err)
+	}
+	modulePaths := readModulePaths()
+
+	rows := [][]string{{"id", "offset", "length", "path"}}
+
+	for id, entry := range entries {
+		// Holes have no data, so no offset either
+		offset := ""
+		if entry.length > 0 && absoluteOffsets {
+			offset = strconv.Itoa(moduleStart + entry.offset)
+		} else if entry.length > 0 {
+			offset = strconv.Itoa(entry.offset)
+		}
+
+		rows = append(rows, []string{
+			strconv.Itoa(id),
+			offset,
+			strconv.Itoa(entry.length),
+			modulePaths[strconv.Itoa(id)],
+		})
+	}
+
+	if csvOutput {
+		writer := csv.NewWriter(os.Stdout)
+		if err := writer.WriteAll(rows); err != nil {
+			fail("%v\n", err)
+		}
+
+		return
+	}
+
+	for _, row := range rows {
+		fmt.Printf("%-8v %-12v %-10v %v\n", row[0], row[1], row[2], row[3])
+	}
+}
+
+type OffsetMapping struct {
+	Startup StartupMapping  `json:"startup"`
+	Modules []ModuleMapping `json:"modules"`
+}
+
+type StartupMapping struct {
+	AbsoluteOffset int `json:"absoluteOffset"`
+	Size           int `json:"size"`
+}
+
+type ModuleMapping struct {
+	ID             int  `json:"id"`
+	Offset         int  `json:"offset"`
+	AbsoluteOffset int  `json:"absoluteOffset"`
+	Length         int  `json:"length"`
+	Empty          bool `json:"empty,omitempty"`
+}
+
+// Read where the startup code and every module of the bundle are
+func readOffsetMapping() OffsetMapping {
+	bundleFile, err := openBundle(bundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	defer bundleFile.Close()
+
+	entries, moduleStart, startupLength, err := readEntryTable(bundleFile)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	mapping := OffsetMapping{
+		Startup: StartupMapping{AbsoluteOffset: moduleStart, Size: startupLength},
+		Modules: []ModuleMapping{},
+	}
+
+	for id, entry := range entries {
+		module := ModuleMapping{ID: id, Length: entry.length, Empty: entry.length == 0}
+		if !module.Empty {
+			module.Offset = entry.offset
+			module.AbsoluteOffset = moduleStart + entry.offset
+		}
+
+		mapping.Modules = append(mapping.Modules, module)
+	}
+
+	return mapping
+}
+
+// Write the original offset of every module in the bundle to a JSON file
+func writeOffsetMapping() {
+	content, err := json.MarshalIndent(readOffsetMapping(), "", "  ")
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	if err := os.WriteFile(mapOutPath, content, 0644); err != nil {
+		fail("%v\n", err)
+	}
+}
+
+// Print the startup code location and the offset and length of every module, without reading them
+func listModules() {
+	mapping := readOffsetMapping()
+
+	if jsonOutput {
+		content, err := json.MarshalIndent(mapping, "", "  ")
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		fmt.Println(string(content))
+		return
+	}
+
+	empty := 0
+	for _, module := range mapping.Modules {
+		if module.Empty {
+			empty++
+		}
+	}
+
+	fmt.Printf("%v modules (%v empty), %v bytes of startup code at offset %v\n", len(mapping.Modules), empty, mapping.Startup.Size, mapping.Startup.AbsoluteOffset)
+	fmt.Printf("%-8v %-12v %v\n", "id", "offset", "length")
+
+	for _, module := range mapping.Modules {
+		if module.Empty {
+			fmt.Printf("%-8v %-12v %v\n", module.ID, "-", "empty")
+			continue
+		}
+
+		fmt.Printf("%-8v %-12v %v\n", module.ID, module.Offset, module.Length)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// Run the regex work of a patch on a module, stopping the run if it takes longer than -regex-timeout
+func runRegexWithTimeout(name string, patchIndex int, moduleID string, fn func()) {
+	if regexTimeout <= 0 {
+		fn()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(regexTimeout):
+		fail("Patch %v#%v timed out on module %v after %v.\n", name, patchIndex, moduleID, regexTimeout)
+	}
+}
+
+// Run a patch on a module for the patch engine, timing it for -profile. Only regexes can run long
+// enough to time out
+func runPatchStep(info PatchInfo, patchIndex int, moduleID string, step func()) {
+	start := time.Now()
+
+	if info.Patches[patchIndex].Regex != nil {
+		runRegexWithTimeout(info.Name, patchIndex, moduleID, step)
+	} else {
+		step()
+	}
+
+	recordPatchTiming(info.Name, patchIndex, time.Since(start))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/NotZoeyDev/jsbundletools/jsbundle"
+)
+
+// The built-in transforms selected with -transform, in their order
+var transforms []jsbundle.Transform
+
+// Select the built-in transforms named on the command line
+func selectTransforms(names string) {
+	for _, name := range strings.Split(names, ",") {
+		if name == "" {
+			continue
+		}
+
+		fn, ok := jsbundle.BuiltinTransform(name)
+		if !ok {
+			fail("Transform %v doesn't exist.\n", name)
+		}
+
+		transforms = append(transforms, jsbundle.Transform{Name: name, Fn: fn})
+	}
+}
+
+// Run the selected transforms on every module
+func applyTransforms(modules *map[string][]byte) {
+	reports, err := jsbundle.ApplyTransforms(moduleSet{modules: modules}, sortedModuleIDs(modules), transforms)
+	if err != nil {
+		fail("%v.\n", err)
+	}
+
+	for _, report := range reports {
+		logger.Printf("Transform %v changed %v modules\n", report.Name, len(report.Changed))
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+type HashCounts struct {
+	Changed int `json:"changed"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// Read the expected module hashes from -hashes, or hash the modules of the -compare bundle
+func expectedHashes() map[string]string {
+	hashes := map[string]string{}
+
+	if hashesPath != "" {
+		content, err := os.ReadFile(hashesPath)
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		if err := json.Unmarshal(content, &hashes); err != nil {
+			fail("Could not parse %v: %v\n", hashesPath, err)
+		}
+
+		return hashes
+	}
+
+	modules, err := readModulesFromBundleFile(compareBundlePath)
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	for id, content := range *modules {
+		hashes[id] = comparedHash(content)
+	}
+
+	return hashes
+}
+
+// Hash a module for comparing, after the -normalize pass if one is set
+func comparedHash(content []byte) string {
+	if normalizeLevel != "" {
+		content = normalizeModule(content, normalizeLevel)
+	}
+
+	return moduleHash(content)
+}
+
+// Report every module whose hash isn't the expected one, exits with an error if any differs
+func verifyHashes(modules *map[string][]byte) {
+	expected := expectedHashes()
+	modulePaths := readModulePaths()
+
+	ids := sortedModuleIDs(modules)
+	for id := range expected {
+		if _, ok := (*modules)[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sortModuleIDs(ids)
+
+	counts := HashCounts{}
+	for _, id := range ids {
+		name := id
+		if path, ok := modulePaths[id]; ok {
+			name = fmt.Sprintf("%v (%v)", id, path)
+		} else if path := moduleVerboseName((*modules)[id]); path != "" {
+			name = fmt.Sprintf("%v (%v)", id, path)
+		}
+
+		content, exists := (*modules)[id]
+		hash, ok := expected[id]
+
+		message := ""
+		switch {
+		case !exists:
+			counts.Removed++
+			message = fmt.Sprintf("Module %v is missing", name)
+		case !ok:
+			counts.Added++
+			message = fmt.Sprintf("Module %v isn't expected", name)
+		case comparedHash(content) != hash:
+			counts.Changed++
+			message = fmt.Sprintf("Module %v differs, expected %v but got %v", name, hash, comparedHash(content))
+		default:
+			continue
+		}
+
+		if !countOnly && !jsonOutput {
+			fmt.Println(message)
+		}
+	}
+
+	mismatches := counts.Changed + counts.Added + counts.Removed
+
+	if jsonOutput {
+		output, err := json.Marshal(counts)
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		fmt.Println(string(output))
+	} else if countOnly {
+		fmt.Printf("changed=%v added=%v removed=%v\n", counts.Changed, counts.Added, counts.Removed)
+	} else if mismatches > 0 {
+		fmt.Printf("%v modules don't match.\n", mismatches)
+	} else {
+		fmt.Printf("All %v modules match.\n", len(ids))
+	}
+
+	if mismatches > 0 {
+		exitFailed()
+	}
+}
+
+// Compare the module ids of the bundle with the ones pack would write from the unpacked folder,
+// exits with an error if any id is added, dropped or moved
+func verifyIDs(modules *map[string][]byte) {
+	files, err := listModuleFiles()
+	if err != nil {
+		fail("%v\n", err)
+	}
+
+	// Modules are matched by content to find the ones that would end up under another id
+	bundleIDs := map[string][]string{}
+	for id, content := range *modules {
+		if len(content) > 0 {
+			bundleIDs[moduleHash(content)] = append(bundleIDs[moduleHash(content)], id)
+		}
+	}
+
+	ids := sortedModuleIDs(modules)
+	for id := range files {
+		if _, ok := (*modules)[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	sortModuleIDs(ids)
+
+	discrepancies := 0
+	for _, id := range ids {
+		path, inFolder := files[id]
+		content, inBundle := (*modules)[id]
+
+		if !inFolder {
+			fmt.Printf("Module %v would be dropped\n", id)
+			discrepancies++
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fail("%v\n", err)
+		}
+
+		if !inBundle {
+			fmt.Printf("Module %v would be added from %v\n", id, path)
+			discrepancies++
+		}
+
+		if len(data) == 0 || inBundle && moduleHash(data) == moduleHash(content) {
+			continue
+		}
+
+		// A file with the content of other bundle modules has been moved from them
+		others := bundleIDs[moduleHash(data)]
+		if len(others) > 0 {
+			fmt.Printf("Module %v has the content of module %v in the bundle\n", id, strings.Join(others, ", "))
+			discrepancies++
+		}
+	}
+
+	bundleCount := tableEntryCount(sortedModuleIDs(modules))
+	packCount := tableEntryCount(slices.Collect(maps.Keys(files)))
+	if bundleCount != packCount {
+		fmt.Printf("The entry table would go from %v to %v entries\n", bundleCount, packCount)
+		discrepancies++
+	}
+
+	if discrepancies > 0 {
+		fail("%v differences between the bundle and %v.\n", discrepancies, outputDir)
+	}
+
+	fmt.Printf("The %v module ids of the bundle match %v.\n", len(ids), outputDir)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+var warningCount int
+
+// Run by exitFailed before it exits, os.Exit skips the deferred calls
+var failHooks []func()
+
+// Run hook if the run fails, the last one added runs first
+func onFail(hook func()) {
+	failHooks = append(failHooks, hook)
+}
+
+// Print an error and exit, errors go to stderr so -quiet doesn't hide them
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format, args...)
+	exitFailed()
+}
+
+// Run the fail hooks and exit with an error, for the modes printing their own failure
+func exitFailed() {
+	// A hook failing exits right away instead of running the hooks again
+	hooks := failHooks
+	failHooks = nil
+	for index := len(hooks) - 1; index >= 0; index-- {
+		hooks[index]()
+	}
+
+	os.Exit(1)
+}
+
+// Report something unusual that doesn't stop the run, every warning goes through here so -Werror
+// can turn all of them into errors
+func warn(format string, args ...any) {
+	warningCount++
+
+	if warningsAsErrors {
+		fail("Error: "+format+"\n", args...)
+	}
+
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", args...)
+	}
+}
+
+// Progress and log lines go through logger so -quiet can turn them off, the data a mode was asked
+// for is printed to stdout either way
+var logger = log.New(os.Stdout, "", 0)
+
+// Point logger at stdout once it's known where stdout goes, nowhere when -quiet is set
+func setupLogger() {
+	if quiet {
+		logger.SetOutput(io.Discard)
+		return
+	}
+
+	logger.SetOutput(os.Stdout)
+}